@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+)
+
+// auditEntry is a Route53 change batch as appended to audit-log once it has been submitted
+type auditEntry struct {
+	Timestamp    time.Time `json:"timestamp"`
+	ChangeID     string    `json:"change_id"`
+	HostedZoneID string    `json:"hosted_zone_id"`
+	AppID        string    `json:"app_id"`
+	RecordSet    string    `json:"record_set"`
+	AddedIPs     []string  `json:"added_ips"`
+	RemovedIPs   []string  `json:"removed_ips"`
+	Comment      string    `json:"comment"`
+}
+
+// auditLogMu serializes the entries of apps updated concurrently and the rotation of the audit log
+var auditLogMu sync.Mutex
+
+// auditChange completes entry with the timestamp and hosted zone and appends it to audit-log. The
+// change has been submitted by then, so failures are only logged.
+func auditChange(cfg config.Config, entry auditEntry) {
+	entry.Timestamp = time.Now().UTC()
+	entry.HostedZoneID = cfg.HostedZoneID
+	if err := writeAuditEntry(cfg.AuditLog, cfg.AuditLogMaxSize, entry); err != nil {
+		log.Printf("WARNING: Unable to write change %s to audit log %s: %v", entry.ChangeID, cfg.AuditLog, err)
+	}
+}
+
+// writeAuditEntry appends entry as a single line of JSON to path, first rotating the file if it has
+// grown beyond maxSize bytes
+func writeAuditEntry(path string, maxSize int64, entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	auditLogMu.Lock()
+	defer auditLogMu.Unlock()
+
+	if info, err := os.Stat(path); err == nil && info.Size()+int64(len(line)) > maxSize && info.Size() > 0 {
+		if err := rotateAuditLog(path); err != nil {
+			return fmt.Errorf("Unable to rotate: %v", err)
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotateAuditLog renames path to path.1, shifting the previously rotated files up by one, e.g. path.1
+// to path.2, so the highest number holds the oldest entries
+func rotateAuditLog(path string) error {
+	last := 0
+	for {
+		if _, err := os.Stat(fmt.Sprintf("%s.%d", path, last+1)); err != nil {
+			break
+		}
+		last++
+	}
+	for idx := last; idx >= 1; idx-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", path, idx), fmt.Sprintf("%s.%d", path, idx+1)); err != nil {
+			return err
+		}
+	}
+	return os.Rename(path, path+".1")
+}
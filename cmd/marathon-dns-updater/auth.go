@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+)
+
+// marathonAuthTransport returns base wrapped to authenticate every request to Marathon, both the API
+// requests and the event stream, with the DC/OS token or basic auth credentials if there are any
+func marathonAuthTransport(cfg config.Config, base http.RoundTripper) http.RoundTripper {
+	if cfg.MarathonOAuthToken != "" {
+		return &tokenTransport{base: base, tokens: staticToken(cfg.MarathonOAuthToken)}
+	}
+	if cfg.MarathonUser != "" {
+		return &basicAuthTransport{base: base, user: cfg.MarathonUser, password: cfg.MarathonPassword}
+	}
+	return base
+}
+
+// tokenSource provides the DC/OS authentication token sent with every request to Marathon. The token
+// is asked for on every request, so an implementation that refreshes it can replace staticToken.
+type tokenSource interface {
+	Token() (string, error)
+}
+
+// staticToken is a token that never changes, e.g. given by marathon-oauth-token
+type staticToken string
+
+func (t staticToken) Token() (string, error) {
+	return string(t), nil
+}
+
+// tokenTransport sends the token of tokens in the Authorization header of every request, as DC/OS
+// expects it, before passing the request on to base
+type tokenTransport struct {
+	base   http.RoundTripper
+	tokens tokenSource
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.tokens.Token()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get the Marathon authentication token: %v", err)
+	}
+
+	// A RoundTripper must not modify the request it is given
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "token="+token)
+	return t.base.RoundTrip(req)
+}
+
+// basicAuthTransport sends the HTTP basic auth credentials user and password with every request
+// before passing it on to base
+type basicAuthTransport struct {
+	base     http.RoundTripper
+	user     string
+	password string
+}
+
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(t.user, t.password)
+	return t.base.RoundTrip(req)
+}
+
+// adminAuth wraps the handler of the admin HTTP server to require the HTTP basic auth credentials user
+// and password for every path but those of public
+func adminAuth(handler http.Handler, user string, password string, public ...string) http.Handler {
+	isPublic := map[string]bool{}
+	for _, path := range public {
+		isPublic[path] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPublic[r.URL.Path] {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		requestUser, requestPassword, ok := r.BasicAuth()
+		// Both are compared so the time taken doesn't tell which one was wrong
+		userOk := subtle.ConstantTimeCompare([]byte(requestUser), []byte(user)) == 1
+		passwordOk := subtle.ConstantTimeCompare([]byte(requestPassword), []byte(password)) == 1
+		if !ok || !userOk || !passwordOk {
+			w.Header().Set("WWW-Authenticate", `Basic realm="marathon-dns-updater"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	marathonapi "github.com/DigDug101/marathon-dns-updater/internal/marathon"
+	"github.com/DigDug101/marathon-dns-updater/internal/testutil"
+	marathon "github.com/gambol99/go-marathon"
+)
+
+func TestEventStreamAuthorization(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		// check returns whether the Authorization header of r is the expected one
+		check func(r *http.Request) bool
+	}{
+		{
+			name: "DC/OS token",
+			env:  map[string]string{"MARATHON_OAUTH_TOKEN": "secret"},
+			check: func(r *http.Request) bool {
+				return r.Header.Get("Authorization") == "token=secret"
+			},
+		},
+		{
+			name: "basic auth",
+			env:  map[string]string{"MARATHON_USER": "updater", "MARATHON_PASSWORD": "secret"},
+			check: func(r *http.Request) bool {
+				user, password, ok := r.BasicAuth()
+				return ok && user == "updater" && password == "secret"
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+			server := testutil.NewMockMarathonServer()
+			defer server.Close()
+
+			cfg := testConfig(t, server)
+			client := &http.Client{Transport: marathonAuthTransport(cfg, server.Client().Transport)}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			api := &marathonapi.API{Client: client, Host: server.URL, Hosts: []string{server.URL}, Path: "v2"}
+			events := make(marathon.EventsChannel, 1)
+			streamErrs := make(chan *marathonapi.StreamError, 1)
+			go api.StreamEvents(ctx, marathonapi.ReconnectPolicy{Delay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond}, events, streamErrs)
+			if !server.WaitForEventStreams(1, 5*time.Second) {
+				t.Fatal("The event stream didn't connect")
+			}
+
+			streams := 0
+			for _, r := range server.Requests() {
+				if r.URL.Path != "/v2/events" {
+					continue
+				}
+				streams++
+				if !test.check(r) {
+					t.Errorf("Unexpected Authorization header on the event stream: %q", r.Header.Get("Authorization"))
+				}
+			}
+			if streams == 0 {
+				t.Error("Expected a request for /v2/events")
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/dns/armdns"
+	"github.com/DigDug101/marathon-dns-updater/internal/dns"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// azureProvider manages records in an Azure DNS zone. Azure DNS has no weighted routing and, like
+// Cloud DNS, replaces entire record sets, so weighted records become the values of a single record
+// set that is rewritten whenever one of its values changes.
+type azureProvider struct {
+	client        *armdns.RecordSetsClient
+	resourceGroup string
+	zone          string
+}
+
+// newAzureProvider creates a provider for the zone authenticating with the managed identity of the
+// host, falling back to the service principal given by the AZURE_* environment variables
+func newAzureProvider(subscriptionID string, resourceGroup string, zone string) (*azureProvider, error) {
+	var sources []azcore.TokenCredential
+	if managedIdentity, err := azidentity.NewManagedIdentityCredential(nil); err == nil {
+		sources = append(sources, managedIdentity)
+	}
+	if environment, err := azidentity.NewEnvironmentCredential(nil); err == nil {
+		sources = append(sources, environment)
+	}
+	credential, err := azidentity.NewChainedTokenCredential(sources, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := armdns.NewRecordSetsClient(subscriptionID, credential, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &azureProvider{
+		client:        client,
+		resourceGroup: resourceGroup,
+		zone:          strings.TrimSuffix(zone, "."),
+	}, nil
+}
+
+func (p *azureProvider) ListRecords(recordSet string, aaaaPrefix string) ([]dns.DNSRecord, error) {
+	var records []dns.DNSRecord
+
+	for _, recordType := range dns.ManagedRecordTypes {
+		pager := p.client.NewListByTypePager(p.resourceGroup, p.zone, armdns.RecordType(recordType), nil)
+		for pager.More() {
+			page, err := pager.NextPage(context.Background())
+			if err != nil {
+				return nil, err
+			}
+			for _, azureSet := range page.Value {
+				if azureSet.Properties == nil || azureSet.Properties.Fqdn == nil {
+					continue
+				}
+				name := strings.TrimSuffix(*azureSet.Properties.Fqdn, ".")
+				if !dns.IsManagedRecordName(recordSet, name, aaaaPrefix) {
+					continue
+				}
+				for _, value := range azureValues(recordType, azureSet.Properties) {
+					records = append(records, dns.DNSRecord{
+						Name:  name,
+						Type:  recordType,
+						Value: value,
+						TTL:   azureTTL(azureSet.Properties.TTL),
+					})
+				}
+			}
+		}
+	}
+
+	return records, nil
+}
+
+func (p *azureProvider) UpsertRecord(record dns.DNSRecord) error {
+	existing, err := p.find(record)
+	if err != nil {
+		return err
+	}
+
+	values := []string{record.Value}
+	if existing != nil {
+		found := false
+		for _, value := range azureValues(record.Type, existing.Properties) {
+			if value == record.Value {
+				found = true
+			} else if record.Type != route53.RRTypeCname {
+				// A CNAME record set holds a single value, which is replaced
+				values = append(values, value)
+			}
+		}
+		if found && azureTTL(existing.Properties.TTL) == record.TTL {
+			return nil
+		}
+	}
+
+	return p.write(record.Name, record.Type, record.TTL, values)
+}
+
+func (p *azureProvider) DeleteRecord(record dns.DNSRecord) error {
+	existing, err := p.find(record)
+	if err != nil || existing == nil {
+		return err
+	}
+
+	var remaining []string
+	values := azureValues(record.Type, existing.Properties)
+	for _, value := range values {
+		if value != record.Value {
+			remaining = append(remaining, value)
+		}
+	}
+	if len(remaining) == len(values) {
+		return nil
+	}
+
+	if len(remaining) > 0 {
+		return p.write(record.Name, record.Type, azureTTL(existing.Properties.TTL), remaining)
+	}
+	_, err = p.client.Delete(context.Background(), p.resourceGroup, p.zone, p.relativeName(record.Name), armdns.RecordType(record.Type), nil)
+	return err
+}
+
+// find returns the record set with the name and type of record, or nil if there is none
+func (p *azureProvider) find(record dns.DNSRecord) (*armdns.RecordSet, error) {
+	resp, err := p.client.Get(context.Background(), p.resourceGroup, p.zone, p.relativeName(record.Name), armdns.RecordType(record.Type), nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if resp.Properties == nil {
+		return nil, nil
+	}
+	return &resp.RecordSet, nil
+}
+
+// write replaces the record set of name and type with one holding values
+func (p *azureProvider) write(name string, recordType string, ttl int64, values []string) error {
+	properties := &armdns.RecordSetProperties{TTL: to.Ptr(ttl)}
+	for _, value := range values {
+		switch recordType {
+		case route53.RRTypeA:
+			properties.ARecords = append(properties.ARecords, &armdns.ARecord{IPv4Address: to.Ptr(value)})
+		case route53.RRTypeAaaa:
+			properties.AaaaRecords = append(properties.AaaaRecords, &armdns.AaaaRecord{IPv6Address: to.Ptr(value)})
+		case route53.RRTypeCname:
+			properties.CnameRecord = &armdns.CnameRecord{Cname: to.Ptr(value)}
+		}
+	}
+
+	_, err := p.client.CreateOrUpdate(context.Background(), p.resourceGroup, p.zone, p.relativeName(name),
+		armdns.RecordType(recordType), armdns.RecordSet{Properties: properties}, nil)
+	return err
+}
+
+// relativeName returns name relative to the zone, as Azure DNS addresses record sets, e.g.
+// marathon-lb for marathon-lb.example.com in example.com and @ for the zone apex
+func (p *azureProvider) relativeName(name string) string {
+	name = strings.TrimSuffix(name, ".")
+	if strings.EqualFold(name, p.zone) {
+		return "@"
+	}
+	return strings.TrimSuffix(name, "."+p.zone)
+}
+
+// azureValues returns the values of the records of recordType in properties
+func azureValues(recordType string, properties *armdns.RecordSetProperties) []string {
+	var values []string
+	if properties == nil {
+		return values
+	}
+	switch recordType {
+	case route53.RRTypeA:
+		for _, record := range properties.ARecords {
+			values = append(values, azureString(record.IPv4Address))
+		}
+	case route53.RRTypeAaaa:
+		for _, record := range properties.AaaaRecords {
+			values = append(values, azureString(record.IPv6Address))
+		}
+	case route53.RRTypeCname:
+		if properties.CnameRecord != nil {
+			values = append(values, azureString(properties.CnameRecord.Cname))
+		}
+	}
+	return values
+}
+
+func azureTTL(ttl *int64) int64 {
+	if ttl == nil {
+		return 0
+	}
+	return *ttl
+}
+
+func azureString(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
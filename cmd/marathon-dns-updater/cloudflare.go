@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/dns"
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// cloudflareProvider manages records in a Cloudflare zone. Cloudflare has no weighted routing, so
+// weighted records are published as plain records sharing the same name.
+type cloudflareProvider struct {
+	api  *cloudflare.API
+	zone *cloudflare.ResourceContainer
+}
+
+func newCloudflareProvider(apiToken string, zoneId string) (*cloudflareProvider, error) {
+	api, err := cloudflare.NewWithAPIToken(apiToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cloudflareProvider{
+		api:  api,
+		zone: cloudflare.ZoneIdentifier(zoneId),
+	}, nil
+}
+
+func (p *cloudflareProvider) ListRecords(recordSet string, aaaaPrefix string) ([]dns.DNSRecord, error) {
+	var records []dns.DNSRecord
+
+	for _, recordType := range dns.ManagedRecordTypes {
+		cfRecords, _, err := p.api.ListDNSRecords(context.Background(), p.zone, cloudflare.ListDNSRecordsParams{
+			Type: recordType,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, cfRecord := range cfRecords {
+			if !dns.IsManagedRecordName(recordSet, cfRecord.Name, aaaaPrefix) {
+				continue
+			}
+			records = append(records, dns.DNSRecord{
+				Name:  cfRecord.Name,
+				Type:  cfRecord.Type,
+				Value: cfRecord.Content,
+				TTL:   int64(cfRecord.TTL),
+			})
+		}
+	}
+
+	return records, nil
+}
+
+func (p *cloudflareProvider) UpsertRecord(record dns.DNSRecord) error {
+	existing, err := p.find(record)
+	if err != nil {
+		return err
+	}
+
+	if len(existing) == 0 {
+		_, err = p.api.CreateDNSRecord(context.Background(), p.zone, cloudflare.CreateDNSRecordParams{
+			Type:    record.Type,
+			Name:    record.Name,
+			Content: record.Value,
+			TTL:     int(record.TTL),
+		})
+		return err
+	}
+
+	for _, cfRecord := range existing {
+		_, err = p.api.UpdateDNSRecord(context.Background(), p.zone, cloudflare.UpdateDNSRecordParams{
+			ID:      cfRecord.ID,
+			Type:    record.Type,
+			Name:    record.Name,
+			Content: record.Value,
+			TTL:     int(record.TTL),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *cloudflareProvider) DeleteRecord(record dns.DNSRecord) error {
+	existing, err := p.find(record)
+	if err != nil {
+		return err
+	}
+
+	for _, cfRecord := range existing {
+		if err := p.api.DeleteDNSRecord(context.Background(), p.zone, cfRecord.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// find returns the cloudflare records matching the name, type and value of record
+func (p *cloudflareProvider) find(record dns.DNSRecord) ([]cloudflare.DNSRecord, error) {
+	cfRecords, _, err := p.api.ListDNSRecords(context.Background(), p.zone, cloudflare.ListDNSRecordsParams{
+		Type:    record.Type,
+		Name:    record.Name,
+		Content: record.Value,
+	})
+	return cfRecords, err
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/dns"
+	consul "github.com/hashicorp/consul/api"
+)
+
+const (
+	// CONSUL_NODE is the external node the service instances of the task IPs are registered on
+	CONSUL_NODE = "marathon-dns-updater"
+
+	CONSUL_RECORD_META = "record"
+	CONSUL_TYPE_META   = "type"
+	CONSUL_TTL_META    = "ttl"
+)
+
+// consulProvider manages records as service instances in the Consul catalog. Every record becomes
+// an instance of the service named after the first label of the record name, e.g. marathon-lb for
+// marathon-lb.example.com, so it resolves as marathon-lb.service.consul. Consul has no weighted
+// routing or TTLs, so weighted records are plain instances of the same service. The TTL of a record is
+// only kept in the meta of its instance, so up to date records can be told apart.
+type consulProvider struct {
+	client     *consul.Client
+	datacenter string
+}
+
+// newConsulProvider creates a provider for the Consul agent at addr, empty values fall back to the
+// defaults of the Consul client, e.g. $CONSUL_HTTP_ADDR and $CONSUL_HTTP_TOKEN
+func newConsulProvider(addr string, token string, datacenter string) (*consulProvider, error) {
+	config := consul.DefaultConfig()
+	if addr != "" {
+		config.Address = addr
+	}
+	if token != "" {
+		config.Token = token
+	}
+	config.Datacenter = datacenter
+
+	client, err := consul.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &consulProvider{
+		client:     client,
+		datacenter: datacenter,
+	}, nil
+}
+
+func (p *consulProvider) ListRecords(recordSet string, aaaaPrefix string) ([]dns.DNSRecord, error) {
+	var records []dns.DNSRecord
+
+	node, _, err := p.client.Catalog().Node(CONSUL_NODE, &consul.QueryOptions{Datacenter: p.datacenter})
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return records, nil
+	}
+
+	for _, service := range node.Services {
+		name := service.Meta[CONSUL_RECORD_META]
+		if name == "" || !dns.IsManagedRecordName(recordSet, name, aaaaPrefix) {
+			continue
+		}
+		ttl, _ := strconv.ParseInt(service.Meta[CONSUL_TTL_META], 10, 64)
+		records = append(records, dns.DNSRecord{
+			Name:  name,
+			Type:  service.Meta[CONSUL_TYPE_META],
+			Value: service.Address,
+			TTL:   ttl,
+		})
+	}
+
+	return records, nil
+}
+
+func (p *consulProvider) UpsertRecord(record dns.DNSRecord) error {
+	_, err := p.client.Catalog().Register(&consul.CatalogRegistration{
+		Node:       CONSUL_NODE,
+		Address:    record.Value,
+		NodeMeta:   map[string]string{"external-node": "true"},
+		Datacenter: p.datacenter,
+		// The node address is only set when the node is first registered, the instances have their own
+		SkipNodeUpdate: true,
+		Service: &consul.AgentService{
+			ID:      consulServiceID(record),
+			Service: consulServiceName(record.Name),
+			Address: record.Value,
+			Tags:    []string{CONSUL_NODE},
+			Meta: map[string]string{
+				CONSUL_RECORD_META: strings.TrimSuffix(record.Name, "."),
+				CONSUL_TYPE_META:   record.Type,
+				CONSUL_TTL_META:    strconv.FormatInt(record.TTL, 10),
+			},
+		},
+	}, nil)
+	return err
+}
+
+func (p *consulProvider) DeleteRecord(record dns.DNSRecord) error {
+	_, err := p.client.Catalog().Deregister(&consul.CatalogDeregistration{
+		Node:       CONSUL_NODE,
+		Datacenter: p.datacenter,
+		ServiceID:  consulServiceID(record),
+	}, nil)
+	return err
+}
+
+// consulServiceName returns the service the records named name are instances of
+func consulServiceName(name string) string {
+	return strings.SplitN(name, ".", 2)[0]
+}
+
+// consulServiceID identifies the service instance of record, which is unique per name and value
+func consulServiceID(record dns.DNSRecord) string {
+	return strings.TrimSuffix(record.Name, ".") + "-" + record.Value
+}
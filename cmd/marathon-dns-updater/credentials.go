@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const (
+	ASSUME_ROLE_DURATION    = 1 * time.Hour
+	ASSUME_ROLE_RETRY_DELAY = 1 * time.Minute
+)
+
+// assumedRole is a credentials.Provider for an IAM role whose temporary credentials are refreshed
+// in the background before they expire
+type assumedRole struct {
+	client      *sts.STS
+	roleArn     string
+	sessionName string
+
+	mu         sync.Mutex
+	value      credentials.Value
+	expiration time.Time
+	retrieved  bool
+}
+
+// newAssumedRole assumes the role using the ambient credentials and keeps its credentials fresh
+// until ctx is cancelled
+func newAssumedRole(ctx context.Context, roleArn string, sessionName string) (*assumedRole, error) {
+	role := &assumedRole{
+		client:      sts.New(session.Must(session.NewSession())),
+		roleArn:     roleArn,
+		sessionName: sessionName,
+	}
+
+	if err := role.refresh(); err != nil {
+		return nil, err
+	}
+	go role.refreshLoop(ctx)
+
+	return role, nil
+}
+
+func (r *assumedRole) refresh() error {
+	out, err := r.client.AssumeRole(&sts.AssumeRoleInput{
+		RoleArn:         aws.String(r.roleArn),
+		RoleSessionName: aws.String(r.sessionName),
+		DurationSeconds: aws.Int64(int64(ASSUME_ROLE_DURATION / time.Second)),
+	})
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.value = credentials.Value{
+		AccessKeyID:     *out.Credentials.AccessKeyId,
+		SecretAccessKey: *out.Credentials.SecretAccessKey,
+		SessionToken:    *out.Credentials.SessionToken,
+		ProviderName:    "AssumeRole",
+	}
+	r.expiration = *out.Credentials.Expiration
+	r.retrieved = false
+
+	return nil
+}
+
+// refreshLoop refreshes the credentials once 80% of their lifetime has passed
+func (r *assumedRole) refreshLoop(ctx context.Context) {
+	for {
+		r.mu.Lock()
+		interval := time.Until(r.expiration) * 4 / 5
+		r.mu.Unlock()
+		log.Printf("Assumed role %s, refreshing credentials in %v", r.roleArn, interval)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+
+			err := r.refresh()
+			if err == nil {
+				break
+			}
+			interval = ASSUME_ROLE_RETRY_DELAY
+			log.Printf("WARNING: Unable to refresh credentials for role %s, retrying in %v: %v", r.roleArn, interval, err)
+		}
+	}
+}
+
+// Retrieve implements credentials.Provider
+func (r *assumedRole) Retrieve() (credentials.Value, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retrieved = true
+	return r.value, nil
+}
+
+// IsExpired implements credentials.Provider, reporting the cached credentials as expired whenever
+// they've been refreshed since they were last retrieved
+func (r *assumedRole) IsExpired() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.retrieved || time.Now().After(r.expiration)
+}
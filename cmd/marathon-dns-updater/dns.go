@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/dns"
+	r53 "github.com/DigDug101/marathon-dns-updater/internal/route53"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// syncRecords applies the planned upserts through a DNSProvider, deleting any managed record that
+// is no longer part of the plan. Unlike the Route53 change batch the changes are not atomic. Records
+// that already exist with the same TTL are left alone. With dryRun the changes are only logged.
+// Records of the published values that are missing from the provider are counted as backfilled.
+func syncRecords(provider dns.DNSProvider, recordSet string, aaaaPrefix string, upserts []*route53.Change, published map[string]bool, dryRun bool) *appError {
+	existing, err := provider.ListRecords(recordSet, aaaaPrefix)
+	if err != nil {
+		return &appError{
+			Err:     fmt.Errorf("Unable to list records for %s: %v", recordSet, err),
+			IsFatal: false,
+		}
+	}
+
+	desired := map[string]dns.DNSRecord{}
+	for _, change := range upserts {
+		record := r53.RecordFromResourceRecordSet(change.ResourceRecordSet)
+		desired[record.Key()] = record
+	}
+
+	present := map[string]dns.DNSRecord{}
+	for _, record := range existing {
+		present[record.Key()] = record
+	}
+	changes := 0
+
+	// Delete out of date records
+	for _, record := range existing {
+		if _, ok := desired[record.Key()]; ok {
+			continue
+		}
+		changes++
+		if dryRun {
+			log.Printf("Planned change: action=DELETE record=%s", record)
+			continue
+		}
+		log.Printf("Deleting record %s", record)
+		if err := provider.DeleteRecord(record); err != nil {
+			return &appError{
+				Err:     fmt.Errorf("Unable to delete record %s: %v", record, err),
+				IsFatal: false,
+			}
+		}
+		appliedChanges.Add(1)
+	}
+
+	// Ensure records for running tasks
+	for _, record := range desired {
+		current, ok := present[record.Key()]
+		if ok && current.TTL == record.TTL {
+			continue
+		}
+		changes++
+		if dryRun {
+			log.Printf("Planned change: action=UPSERT record=%s", record)
+			continue
+		}
+		backfill := !ok && published[record.Value]
+		if backfill {
+			log.Printf("WARNING: Backfilling record %s, which is missing although it was published", record)
+		} else {
+			log.Printf("Creating record %s", record)
+		}
+		if err := provider.UpsertRecord(record); err != nil {
+			return &appError{
+				Err:     fmt.Errorf("Unable to upsert record %s: %v", record, err),
+				IsFatal: false,
+			}
+		}
+		if backfill {
+			appMetrics.recordsBackfilled.Inc()
+		}
+		appliedChanges.Add(1)
+	}
+
+	if changes == 0 {
+		log.Printf("No changes required for %s", recordSet)
+		appMetrics.updatesSkippedNoop.Inc()
+	} else if dryRun {
+		log.Printf("Dry run, not applying changes for %s", recordSet)
+	} else {
+		log.Printf("Updated records for %s successfully.", recordSet)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/dns"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSyncRecordsSkipsUpToDateRecords(t *testing.T) {
+	cfg := parseTestConfig(t)
+	provider := newFakeProvider(
+		dns.DNSRecord{Name: "marathon-lb.example.com", Type: "A", Value: "10.0.0.1", TTL: 60},
+		dns.DNSRecord{Name: "marathon-lb-1.example.com", Type: "A", Value: "10.0.0.1", TTL: 60},
+	)
+
+	skipped := promtestutil.ToFloat64(appMetrics.updatesSkippedNoop)
+	if appErr := updateRecords(context.Background(), cfg, newMockMarathonClient(runningApp("10.0.0.1")), provider, testTarget(cfg)); appErr != nil {
+		t.Fatalf("Update failed: %v", appErr.Err)
+	}
+	if len(provider.upserts) != 0 {
+		t.Errorf("Expected the up to date records to be left alone, got upserts of %v", provider.upserts)
+	}
+	if got := promtestutil.ToFloat64(appMetrics.updatesSkippedNoop) - skipped; got != 1 {
+		t.Errorf("Expected dns_updates_skipped_noop_total to increase by 1, got %v", got)
+	}
+
+	// Only the records of a new task are upserted
+	if appErr := updateRecords(context.Background(), cfg, newMockMarathonClient(runningApp("10.0.0.1", "10.0.0.2")), provider, testTarget(cfg)); appErr != nil {
+		t.Fatalf("Update failed: %v", appErr.Err)
+	}
+	want := []string{"marathon-lb-2.example.com A 10.0.0.2", "marathon-lb.example.com A 10.0.0.2"}
+	upserts := append([]string(nil), provider.upserts...)
+	sort.Strings(upserts)
+	if !equalStrings(upserts, want) {
+		t.Errorf("Expected upserts of %v, got %v", want, upserts)
+	}
+}
+
+func TestSyncRecordsUpdatesChangedTTL(t *testing.T) {
+	cfg := parseTestConfig(t)
+	provider := newFakeProvider(
+		dns.DNSRecord{Name: "marathon-lb.example.com", Type: "A", Value: "10.0.0.1", TTL: 300},
+		dns.DNSRecord{Name: "marathon-lb-1.example.com", Type: "A", Value: "10.0.0.1", TTL: 60},
+	)
+
+	if appErr := updateRecords(context.Background(), cfg, newMockMarathonClient(runningApp("10.0.0.1")), provider, testTarget(cfg)); appErr != nil {
+		t.Fatalf("Update failed: %v", appErr.Err)
+	}
+	if !equalStrings(provider.upserts, []string{"marathon-lb.example.com A 10.0.0.1"}) {
+		t.Errorf("Expected only the record with the old TTL to be upserted, got %v", provider.upserts)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/dns"
+	clouddns "google.golang.org/api/dns/v1"
+	"google.golang.org/api/option"
+)
+
+const (
+	googleChangePollInterval = 2 * time.Second
+	googleChangeTimeout      = 5 * time.Minute
+)
+
+// googleProvider manages records in a Google Cloud DNS managed zone. Cloud DNS has no weighted
+// routing, so weighted records become the values of a single record set. Every record is changed
+// with its own change, which is waited on until it is done like the Route53 change batch.
+type googleProvider struct {
+	service *clouddns.Service
+	project string
+	zone    string
+}
+
+// newGoogleProvider creates a provider for the managed zone using the application default credentials,
+// unless opts say otherwise
+func newGoogleProvider(project string, zone string, opts ...option.ClientOption) (*googleProvider, error) {
+	service, err := clouddns.NewService(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &googleProvider{
+		service: service,
+		project: project,
+		zone:    zone,
+	}, nil
+}
+
+func (p *googleProvider) ListRecords(recordSet string, aaaaPrefix string) ([]dns.DNSRecord, error) {
+	var records []dns.DNSRecord
+
+	err := p.service.ResourceRecordSets.List(p.project, p.zone).Pages(context.Background(), func(page *clouddns.ResourceRecordSetsListResponse) error {
+		for _, rrset := range page.Rrsets {
+			if !dns.IsManagedRecordType(rrset.Type) || !dns.IsManagedRecordName(recordSet, rrset.Name, aaaaPrefix) {
+				continue
+			}
+			for _, value := range rrset.Rrdatas {
+				records = append(records, dns.DNSRecord{
+					Name:  strings.TrimSuffix(rrset.Name, "."),
+					Type:  rrset.Type,
+					Value: value,
+					TTL:   rrset.Ttl,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (p *googleProvider) UpsertRecord(record dns.DNSRecord) error {
+	existing, err := p.find(record)
+	if err != nil {
+		return err
+	}
+
+	updated := &clouddns.ResourceRecordSet{
+		Name:    googleName(record.Name),
+		Type:    record.Type,
+		Ttl:     record.TTL,
+		Rrdatas: []string{record.Value},
+	}
+	change := &clouddns.Change{Additions: []*clouddns.ResourceRecordSet{updated}}
+
+	if existing != nil {
+		found := false
+		for _, value := range existing.Rrdatas {
+			if value == record.Value {
+				found = true
+			} else {
+				updated.Rrdatas = append(updated.Rrdatas, value)
+			}
+		}
+		if found && existing.Ttl == record.TTL {
+			return nil
+		}
+		change.Deletions = []*clouddns.ResourceRecordSet{existing}
+	}
+
+	return p.apply(change)
+}
+
+func (p *googleProvider) DeleteRecord(record dns.DNSRecord) error {
+	existing, err := p.find(record)
+	if err != nil || existing == nil {
+		return err
+	}
+
+	var remaining []string
+	for _, value := range existing.Rrdatas {
+		if value != record.Value {
+			remaining = append(remaining, value)
+		}
+	}
+	if len(remaining) == len(existing.Rrdatas) {
+		return nil
+	}
+
+	change := &clouddns.Change{Deletions: []*clouddns.ResourceRecordSet{existing}}
+	if len(remaining) > 0 {
+		change.Additions = []*clouddns.ResourceRecordSet{{
+			Name:    existing.Name,
+			Type:    existing.Type,
+			Ttl:     existing.Ttl,
+			Rrdatas: remaining,
+		}}
+	}
+
+	return p.apply(change)
+}
+
+// find returns the record set with the name and type of record, or nil if there is none
+func (p *googleProvider) find(record dns.DNSRecord) (*clouddns.ResourceRecordSet, error) {
+	resp, err := p.service.ResourceRecordSets.List(p.project, p.zone).
+		Name(googleName(record.Name)).
+		Type(record.Type).
+		Do()
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Rrsets) == 0 {
+		return nil, nil
+	}
+	return resp.Rrsets[0], nil
+}
+
+// apply submits change and waits for it to be done
+func (p *googleProvider) apply(change *clouddns.Change) error {
+	change, err := p.service.Changes.Create(p.project, p.zone, change).Do()
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(googleChangeTimeout)
+	for change.Status != "done" {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("change %s is still %s after %v", change.Id, change.Status, googleChangeTimeout)
+		}
+		time.Sleep(googleChangePollInterval)
+
+		change, err = p.service.Changes.Get(p.project, p.zone, change.Id).Do()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// googleName returns name as the fully qualified name Cloud DNS expects
+func googleName(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
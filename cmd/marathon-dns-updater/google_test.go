@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/dns"
+	clouddns "google.golang.org/api/dns/v1"
+	"google.golang.org/api/option"
+)
+
+// fakeCloudDNS serves the record sets and changes of the managed zone example-zone of the project
+// example-project from memory. Changes are done right away.
+type fakeCloudDNS struct {
+	mu     sync.Mutex
+	rrsets map[string]*clouddns.ResourceRecordSet
+	// changes holds the changes submitted so far
+	changes []*clouddns.Change
+}
+
+func newFakeCloudDNS(t *testing.T) (*fakeCloudDNS, *httptest.Server) {
+	f := &fakeCloudDNS{rrsets: map[string]*clouddns.ResourceRecordSet{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /dns/v1/projects/example-project/managedZones/example-zone/rrsets", f.listRecordSets)
+	mux.HandleFunc("POST /dns/v1/projects/example-project/managedZones/example-zone/changes", f.createChange)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeCloudDNS) listRecordSets(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name, recordType := r.URL.Query().Get("name"), r.URL.Query().Get("type")
+	resp := &clouddns.ResourceRecordSetsListResponse{Rrsets: []*clouddns.ResourceRecordSet{}}
+	for _, rrset := range f.rrsets {
+		if (name == "" || rrset.Name == name) && (recordType == "" || rrset.Type == recordType) {
+			resp.Rrsets = append(resp.Rrsets, rrset)
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (f *fakeCloudDNS) createChange(w http.ResponseWriter, r *http.Request) {
+	change := &clouddns.Change{}
+	if err := json.NewDecoder(r.Body).Decode(change); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, deletion := range change.Deletions {
+		if _, ok := f.rrsets[deletion.Name+" "+deletion.Type]; !ok {
+			http.Error(w, "record set "+deletion.Name+" does not exist", http.StatusConflict)
+			return
+		}
+	}
+	for _, deletion := range change.Deletions {
+		delete(f.rrsets, deletion.Name+" "+deletion.Type)
+	}
+	for _, addition := range change.Additions {
+		f.rrsets[addition.Name+" "+addition.Type] = addition
+	}
+	f.changes = append(f.changes, change)
+	change.Id = "1"
+	change.Status = "done"
+	json.NewEncoder(w).Encode(change)
+}
+
+// values returns the sorted values of the record set of name and type A
+func (f *fakeCloudDNS) values(name string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rrset, ok := f.rrsets[name+" A"]
+	if !ok {
+		return nil
+	}
+	values := append([]string(nil), rrset.Rrdatas...)
+	sort.Strings(values)
+	return values
+}
+
+func TestGoogleProvider(t *testing.T) {
+	fake, server := newFakeCloudDNS(t)
+	provider, err := newGoogleProvider("example-project", "example-zone",
+		option.WithEndpoint(server.URL+"/"), option.WithoutAuthentication(), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Weighted records become the values of a single record set
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		if err := provider.UpsertRecord(dns.DNSRecord{Name: "marathon-lb.example.com", Type: "A", Value: ip, TTL: 60}); err != nil {
+			t.Fatalf("Upsert of %s failed: %v", ip, err)
+		}
+	}
+	if values := fake.values("marathon-lb.example.com."); !equalStrings(values, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Fatalf("Expected a record set of both IPs, got %v", values)
+	}
+
+	// Upserting a record that is up to date changes nothing
+	if err := provider.UpsertRecord(dns.DNSRecord{Name: "marathon-lb.example.com", Type: "A", Value: "10.0.0.1", TTL: 60}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.changes) != 2 {
+		t.Errorf("Expected 2 changes, got %d", len(fake.changes))
+	}
+
+	records, err := provider.ListRecords("marathon-lb.example.com", "ipv6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []string
+	for _, record := range records {
+		listed = append(listed, record.Key())
+	}
+	sort.Strings(listed)
+	want := []string{
+		dns.DNSRecord{Name: "marathon-lb.example.com", Type: "A", Value: "10.0.0.1"}.Key(),
+		dns.DNSRecord{Name: "marathon-lb.example.com", Type: "A", Value: "10.0.0.2"}.Key(),
+	}
+	if !equalStrings(listed, want) {
+		t.Errorf("Expected the records %v, got %v", want, listed)
+	}
+
+	if err := provider.DeleteRecord(dns.DNSRecord{Name: "marathon-lb.example.com", Type: "A", Value: "10.0.0.1", TTL: 60}); err != nil {
+		t.Fatal(err)
+	}
+	if values := fake.values("marathon-lb.example.com."); !equalStrings(values, []string{"10.0.0.2"}) {
+		t.Fatalf("Expected the record set to keep 10.0.0.2, got %v", values)
+	}
+
+	// Deleting the last value deletes the record set
+	if err := provider.DeleteRecord(dns.DNSRecord{Name: "marathon-lb.example.com", Type: "A", Value: "10.0.0.2", TTL: 60}); err != nil {
+		t.Fatal(err)
+	}
+	if values := fake.values("marathon-lb.example.com."); values != nil {
+		t.Errorf("Expected the record set to be deleted, got %v", values)
+	}
+}
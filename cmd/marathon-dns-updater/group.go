@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+	"github.com/DigDug101/marathon-dns-updater/internal/dns"
+	r53 "github.com/DigDug101/marathon-dns-updater/internal/route53"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	marathon "github.com/gambol99/go-marathon"
+)
+
+// discoveredApps are the app record sets found by the latest discovery of app-id-regex by status key,
+// so that the records of apps that no longer match can be deleted
+var discoveredApps = struct {
+	sync.Mutex
+	targets map[string]config.AppRecordSet
+}{targets: map[string]config.AppRecordSet{}}
+
+// resolveApps returns cfg with the app record sets of the apps of app-group or app-id-regex as of now,
+// or cfg itself if the apps are static
+func resolveApps(cfg config.Config, client MarathonClient) (config.Config, error) {
+	if cfg.AppIDRegex != nil {
+		return discoverApps(cfg, client)
+	}
+	return resolveAppGroup(cfg, client)
+}
+
+// resolveAppGroup returns cfg with an app record set for every app in cfg.AppGroup, or cfg itself if
+// no group is configured. The record set of an app is named after its base name within the group's
+// record set, e.g. public.marathon-lb.example.com for /infra/lb/public.
+func resolveAppGroup(cfg config.Config, client MarathonClient) (config.Config, error) {
+	if cfg.AppGroup == "" {
+		return cfg, nil
+	}
+
+	group, err := client.Group(cfg.AppGroup)
+	if err != nil {
+		appMetrics.marathonFetchErrors.Inc()
+		return cfg, fmt.Errorf("Unable to fetch marathon group %s: %v", cfg.AppGroup, err)
+	}
+
+	cfg.AppRecordSets = nil
+	apps := groupApps(group)
+	for _, app := range apps {
+		if !config.MatchesLabels(cfg.LabelSelectors, app.Labels) {
+			continue
+		}
+		recordSet := config.DecoratedRecordSet(cfg, path.Base(app.ID)+"."+cfg.AppGroupRecordSet)
+		if err := config.ValidateRecordSetName(recordSet); err != nil {
+			log.Printf("WARNING: Skipping appId: %s, %v", app.ID, err)
+			continue
+		}
+		cfg.AppRecordSets = append(cfg.AppRecordSets, config.AppRecordSet{AppID: app.ID, RecordSet: recordSet})
+	}
+	if len(cfg.LabelSelectors) > 0 {
+		log.Printf("Found %d apps in marathon group %s, skipped %d not matching label-selector",
+			len(apps), cfg.AppGroup, len(apps)-len(cfg.AppRecordSets))
+	}
+	if len(cfg.AppRecordSets) == 0 {
+		return cfg, fmt.Errorf("No apps found in marathon group %s", cfg.AppGroup)
+	}
+
+	return cfg, nil
+}
+
+// discoverApps returns cfg with an app record set for every Marathon app whose id matches
+// cfg.AppIDRegex. The record set of an app is named after its id within the configured record set,
+// e.g. infra-lb-public.marathon-lb.example.com for /infra/lb-public.
+func discoverApps(cfg config.Config, client MarathonClient) (config.Config, error) {
+	apps, err := client.Applications(nil)
+	if err != nil {
+		appMetrics.marathonFetchErrors.Inc()
+		return cfg, fmt.Errorf("Unable to list marathon apps: %v", err)
+	}
+
+	cfg.AppRecordSets = nil
+	matching, skipped := 0, 0
+	for _, app := range apps.Apps {
+		if !cfg.AppIDRegex.MatchString(app.ID) {
+			continue
+		}
+		matching++
+		if !config.MatchesLabels(cfg.LabelSelectors, app.Labels) {
+			skipped++
+			continue
+		}
+		recordSet := config.DecoratedRecordSet(cfg, appRecordSetLabel(app.ID)+"."+cfg.AppGroupRecordSet)
+		if err := config.ValidateRecordSetName(recordSet); err != nil {
+			log.Printf("WARNING: Skipping appId: %s, %v", app.ID, err)
+			continue
+		}
+		cfg.AppRecordSets = append(cfg.AppRecordSets, config.AppRecordSet{AppID: app.ID, RecordSet: recordSet})
+	}
+	if len(cfg.LabelSelectors) > 0 {
+		log.Printf("Found %d apps matching app-id-regex, skipped %d not matching label-selector", matching, skipped)
+	}
+	if len(cfg.AppRecordSets) == 0 {
+		return cfg, fmt.Errorf("No marathon apps match app-id-regex %s", cfg.AppIDRegex)
+	}
+
+	return cfg, nil
+}
+
+// appRecordSetLabel returns the DNS label naming the record set of appID, its path joined by dashes
+func appRecordSetLabel(appID string) string {
+	return dnsLabel(strings.Replace(strings.Trim(appID, "/"), "/", "-", -1))
+}
+
+// removeVanishedApps deletes the records of the apps discovered by a previous update that are not
+// among the app record sets of cfg anymore. Apps whose records can't be deleted are retried on the
+// next update. Apps removed while the updater wasn't running are not cleaned up.
+func removeVanishedApps(ctx context.Context, cfg config.Config, provider dns.DNSProvider) {
+	current := map[string]config.AppRecordSet{}
+	for _, target := range cfg.AppRecordSets {
+		current[statusKey(target)] = target
+	}
+
+	discoveredApps.Lock()
+	defer discoveredApps.Unlock()
+	for key, target := range discoveredApps.targets {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		log.Printf("App %s no longer matches app-id-regex, deleting the records of %s", target.AppID, target.RecordSet)
+		if err := deleteRecords(ctx, cfg, provider, target); err != nil {
+			log.Printf("WARNING: Unable to delete the records of %s: %v", target.RecordSet, err)
+			current[key] = target
+			continue
+		}
+		if !cfg.DryRun {
+			currentStatus.remove(target)
+		}
+	}
+	discoveredApps.targets = current
+}
+
+// deleteRecords deletes all records of target, and with the route53 dns-provider the health checks
+// created for them
+func deleteRecords(ctx context.Context, cfg config.Config, provider dns.DNSProvider, target config.AppRecordSet) *appError {
+	r53Provider, ok := provider.(*r53.Provider)
+	if !ok {
+		return syncRecords(provider, target.RecordSet, cfg.AAAAEnumeratedPrefix, nil, nil, cfg.DryRun)
+	}
+
+	recordSets, err := r53Provider.ListRecordSets(ctx, cfg.HostedZoneID, target.RecordSet)
+	if err != nil {
+		appMetrics.route53APIErrors.WithLabelValues(r53.ErrorCode(err)).Inc()
+		return &appError{Err: fmt.Errorf("Unable to list record sets: %v", err), IsFatal: false}
+	}
+
+	var deletes []*route53.Change
+	for _, existing := range recordSets {
+		if !isManagedRecordSet(cfg, target.RecordSet, existing) {
+			continue
+		}
+		if cfg.DryRun {
+			log.Printf("Planned change: action=DELETE name=%s type=%s", *existing.Name, *existing.Type)
+			continue
+		}
+		deletes = append(deletes, &route53.Change{
+			Action:            aws.String(route53.ChangeActionDelete),
+			ResourceRecordSet: existing,
+		})
+	}
+	if len(deletes) == 0 {
+		return nil
+	}
+
+	comment := "Records of a vanished app, deleted by marathon-dns-updater"
+	release, err := r53Provider.Throttle(ctx)
+	if err != nil {
+		return &appError{Err: err, IsFatal: false}
+	}
+	result, err := r53Provider.Client.ChangeResourceRecordSetsWithContext(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(cfg.HostedZoneID),
+		ChangeBatch: &route53.ChangeBatch{
+			Comment: aws.String(comment),
+			Changes: deletes,
+		},
+	})
+	release()
+	if err != nil {
+		appMetrics.route53APIErrors.WithLabelValues(r53.ErrorCode(err)).Inc()
+		return &appError{Err: err, IsFatal: false}
+	}
+
+	if cfg.AuditLog != "" {
+		removed := []string{}
+		for _, change := range deletes {
+			for _, record := range change.ResourceRecordSet.ResourceRecords {
+				removed = append(removed, aws.StringValue(record.Value))
+			}
+		}
+		auditChange(cfg, auditEntry{
+			ChangeID:   aws.StringValue(result.ChangeInfo.Id),
+			AppID:      target.AppID,
+			RecordSet:  target.RecordSet,
+			AddedIPs:   []string{},
+			RemovedIPs: removed,
+			Comment:    comment,
+		})
+	}
+
+	if cfg.CreateHealthChecks || cfg.RecordSetTypes[config.FAILOVER_PRIMARY] {
+		healthChecks, err := r53Provider.TaggedHealthChecks(ctx, target.RecordSet, func(*route53.HealthCheck) bool { return true })
+		if err != nil {
+			log.Printf("WARNING: Unable to list the health checks of %s: %v", target.RecordSet, err)
+			return nil
+		}
+		// A health check can't be deleted while a calculated health check refers to it, so the
+		// calculated health checks go first
+		var ids, children []string
+		for _, check := range healthChecks {
+			if r53.IsCalculatedHealthCheck(check.HealthCheck) {
+				ids = append(ids, *check.HealthCheck.Id)
+			} else {
+				children = append(children, *check.HealthCheck.Id)
+			}
+		}
+		r53Provider.DeleteHealthChecks(ctx, append(ids, children...))
+	}
+	return nil
+}
+
+// groupApps returns the apps of group and all of its sub groups
+func groupApps(group *marathon.Group) []*marathon.Application {
+	var apps []*marathon.Application
+	apps = append(apps, group.Apps...)
+	for _, subGroup := range group.Groups {
+		apps = append(apps, groupApps(subGroup)...)
+	}
+	return apps
+}
+
+// watchedApps matches the ids of the apps whose events can affect the records
+type watchedApps struct {
+	appIds      map[string]bool
+	groupPrefix string
+	pattern     *regexp.Regexp
+	// deployments is set with watch-deployments, failed deployments of the apps are then logged
+	deployments bool
+}
+
+func newWatchedApps(cfg config.Config) watchedApps {
+	watched := watchedApps{appIds: map[string]bool{}}
+	for _, target := range cfg.AppRecordSets {
+		watched.appIds[target.AppID] = true
+	}
+	if cfg.AppGroup != "" {
+		watched.groupPrefix = cfg.AppGroup + "/"
+	}
+	watched.pattern = cfg.AppIDRegex
+	watched.deployments = cfg.WatchDeployments
+	return watched
+}
+
+func (w watchedApps) contains(appID string) bool {
+	return w.appIds[appID] || (w.groupPrefix != "" && strings.HasPrefix(appID, w.groupPrefix)) ||
+		(w.pattern != nil && w.pattern.MatchString(appID))
+}
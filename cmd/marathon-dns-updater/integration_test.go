@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+	"github.com/DigDug101/marathon-dns-updater/internal/dns"
+	marathonapi "github.com/DigDug101/marathon-dns-updater/internal/marathon"
+	"github.com/DigDug101/marathon-dns-updater/internal/testutil"
+	marathon "github.com/gambol99/go-marathon"
+)
+
+// fakeProvider is an in-memory DNSProvider
+type fakeProvider struct {
+	mu      sync.Mutex
+	records map[string]dns.DNSRecord
+	// upserts holds the keys of the records upserted so far
+	upserts []string
+}
+
+func newFakeProvider(records ...dns.DNSRecord) *fakeProvider {
+	p := &fakeProvider{records: map[string]dns.DNSRecord{}}
+	for _, record := range records {
+		p.records[record.Key()] = record
+	}
+	return p
+}
+
+func (p *fakeProvider) ListRecords(recordSet string, aaaaPrefix string) ([]dns.DNSRecord, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var records []dns.DNSRecord
+	for _, record := range p.records {
+		if dns.IsManagedRecordName(recordSet, record.Name, aaaaPrefix) && dns.IsManagedRecordType(record.Type) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func (p *fakeProvider) UpsertRecord(record dns.DNSRecord) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.records[record.Key()] = record
+	p.upserts = append(p.upserts, record.Key())
+	return nil
+}
+
+func (p *fakeProvider) DeleteRecord(record dns.DNSRecord) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.records, record.Key())
+	return nil
+}
+
+// values returns the sorted values of the records named name
+func (p *fakeProvider) values(name string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var values []string
+	for _, record := range p.records {
+		if strings.TrimSuffix(record.Name, ".") == name {
+			values = append(values, record.Value)
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+// parseTestConfig parses the config of the weighted and enumerated records of
+// marathon-lb.example.com for the app /marathon-lb, followed by args
+func parseTestConfig(t *testing.T, args ...string) config.Config {
+	t.Helper()
+	fs := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	cfg, err := config.ParseConfig(fs, append([]string{
+		"-hosted-zone-id", "Z1",
+		"-app-id", "/marathon-lb",
+		"-record-set", "marathon-lb.example.com",
+		"-record-set-type", "weighted,enumerated",
+	}, args...))
+	if err != nil {
+		t.Fatalf("Unable to parse config: %v", err)
+	}
+	return cfg
+}
+
+// testConfig is the parseTestConfig of the Marathon of server
+func testConfig(t *testing.T, server *testutil.MockMarathonServer, args ...string) config.Config {
+	t.Helper()
+	return parseTestConfig(t, append([]string{"-marathon-host", server.URL}, args...)...)
+}
+
+// testTarget is the record set of the app of testConfig
+func testTarget(cfg config.Config) config.AppRecordSet {
+	return cfg.AppRecordSets[0]
+}
+
+// runningApp returns a marathon-lb app with a running task for each of ips
+func runningApp(ips ...string) *marathon.Application {
+	app := &marathon.Application{ID: "/marathon-lb"}
+	for idx, ip := range ips {
+		app.Tasks = append(app.Tasks, &marathon.Task{
+			ID:          "marathon-lb." + string(rune('a'+idx)),
+			AppID:       "/marathon-lb",
+			Host:        "agent-" + ip,
+			State:       marathonapi.TaskRunning,
+			IPAddresses: []*marathon.IPAddress{{IPAddress: ip, Protocol: "IPv4"}},
+		})
+	}
+	return app
+}
+
+// testMarathonClient creates the go-marathon client of cfg against the mock server
+func testMarathonClient(t *testing.T, cfg config.Config, server *testutil.MockMarathonServer) MarathonClient {
+	t.Helper()
+	client, err := newMarathonClient(cfg, server.Client())
+	if err != nil {
+		t.Fatalf("Unable to create Marathon client: %v", err)
+	}
+	return client
+}
+
+func equalStrings(a []string, b []string) bool {
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
+
+func TestIntegrationUpdateOnStatusUpdate(t *testing.T) {
+	server := testutil.NewMockMarathonServer()
+	defer server.Close()
+	server.SetApp(runningApp("10.0.0.1"))
+
+	cfg := testConfig(t, server)
+	client := testMarathonClient(t, cfg, server)
+	provider := newFakeProvider()
+	target := testTarget(cfg)
+
+	if appErr := updateRecords(context.Background(), cfg, client, provider, target); appErr != nil {
+		t.Fatalf("Initial update failed: %v", appErr.Err)
+	}
+	if values := provider.values("marathon-lb.example.com"); !equalStrings(values, []string{"10.0.0.1"}) {
+		t.Fatalf("Expected the weighted record of 10.0.0.1, got %v", values)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api := &marathonapi.API{Client: server.Client(), Host: server.URL, Hosts: []string{server.URL}, Path: "v2"}
+	events := make(marathon.EventsChannel, 1)
+	streamErrs := make(chan *marathonapi.StreamError, 1)
+	go api.StreamEvents(ctx, marathonapi.ReconnectPolicy{Delay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond}, events, streamErrs)
+	if !server.WaitForEventStreams(1, 5*time.Second) {
+		t.Fatal("The event stream didn't connect")
+	}
+
+	// A second task starts
+	server.SetApp(runningApp("10.0.0.1", "10.0.0.2"))
+	if err := server.FireStatusUpdate("/marathon-lb", "marathon-lb.b", marathonapi.TaskRunning, "agent-10.0.0.2", "10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	watched := newWatchedApps(cfg)
+	select {
+	case event := <-events:
+		if !isWatchedEvent(event, watched) {
+			t.Fatalf("Expected the status update of /marathon-lb to trigger an update, got %v", marathonapi.EventAppIds(event))
+		}
+	case err := <-streamErrs:
+		t.Fatalf("Event stream failed: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event received")
+	}
+
+	if appErr := updateRecords(context.Background(), cfg, client, provider, target); appErr != nil {
+		t.Fatalf("Update failed: %v", appErr.Err)
+	}
+	if values := provider.values("marathon-lb.example.com"); !equalStrings(values, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("Expected the weighted records of both tasks, got %v", values)
+	}
+	if values := provider.values("marathon-lb-2.example.com"); !equalStrings(values, []string{"10.0.0.2"}) {
+		t.Errorf("Expected an enumerated record of the new task, got %v", values)
+	}
+}
+
+func TestIntegrationNoRunningTasksIsFatal(t *testing.T) {
+	server := testutil.NewMockMarathonServer()
+	defer server.Close()
+	app := runningApp("10.0.0.1")
+	app.Tasks[0].State = marathonapi.TaskStaging
+	server.SetApp(app)
+
+	cfg := testConfig(t, server)
+	provider := newFakeProvider(dns.DNSRecord{Name: "marathon-lb-1.example.com", Type: "A", Value: "10.0.0.9", TTL: 60})
+
+	appErr := updateRecords(context.Background(), cfg, testMarathonClient(t, cfg, server), provider, testTarget(cfg))
+	if appErr == nil {
+		t.Fatal("Expected an error without running tasks")
+	}
+	if !appErr.IsFatal || !strings.Contains(appErr.Err.Error(), "No running tasks found for appId: /marathon-lb") {
+		t.Errorf("Expected a fatal error about the missing tasks, got %v (fatal: %v)", appErr.Err, appErr.IsFatal)
+	}
+	if values := provider.values("marathon-lb-1.example.com"); !equalStrings(values, []string{"10.0.0.9"}) {
+		t.Errorf("Expected the records to be left alone, got %v", values)
+	}
+}
+
+func TestIntegrationRecoversFromMarathonError(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Waits for the health check of go-marathon to mark the host up again")
+	}
+	state, err := loadStateFile(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := lastKnownState
+	lastKnownState = state
+	defer func() { lastKnownState = previous }()
+
+	server := testutil.NewMockMarathonServer()
+	defer server.Close()
+	server.SetApp(runningApp("10.0.0.1"))
+
+	cfg := testConfig(t, server)
+	client := testMarathonClient(t, cfg, server)
+	provider := newFakeProvider()
+	target := testTarget(cfg)
+
+	if appErr := updateRecords(context.Background(), cfg, client, provider, target); appErr != nil {
+		t.Fatalf("Initial update failed: %v", appErr.Err)
+	}
+
+	// Marathon fails while a task is replaced, the records of the last update are kept
+	server.SetApp(runningApp("10.0.0.2"))
+	server.FailRequests(1)
+	appErr := updateRecords(context.Background(), cfg, client, provider, target)
+	if appErr == nil || appErr.IsFatal {
+		t.Fatalf("Expected a non-fatal error while Marathon fails, got %v", appErr)
+	}
+	if values := provider.values("marathon-lb.example.com"); !equalStrings(values, []string{"10.0.0.1"}) {
+		t.Fatalf("Expected the records of the last update to be kept, got %v", values)
+	}
+
+	// go-marathon marks the host down after the 500 and only sends requests to it again once it
+	// answers the /ping of its health check, which runs every 5 seconds
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		appErr := updateRecords(context.Background(), cfg, client, provider, target)
+		if appErr == nil {
+			break
+		}
+		if appErr.IsFatal || time.Now().After(deadline) {
+			t.Fatalf("Expected the update to succeed once Marathon recovered, got %v (fatal: %v)", appErr.Err, appErr.IsFatal)
+		}
+		if values := provider.values("marathon-lb.example.com"); !equalStrings(values, []string{"10.0.0.1"}) {
+			t.Fatalf("Expected the records of the last update to be kept while the host is down, got %v", values)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	pinged := false
+	for _, r := range server.Requests() {
+		pinged = pinged || r.URL.Path == "/ping"
+	}
+	if !pinged {
+		t.Error("Expected the host to be marked up again by a /ping")
+	}
+	if values := provider.values("marathon-lb.example.com"); !equalStrings(values, []string{"10.0.0.2"}) {
+		t.Errorf("Expected the records to point at the new task, got %v", values)
+	}
+}
+
+// deploymentEvent is the payload of a deployment event of Marathon 1.x for a deployment scaling
+// /marathon-lb and restarting /other
+const deploymentEvent = `{
+  "id": "867ed450-f6a8-4d33-9b0e-e11c5513990b",
+  "eventType": "%s",
+  "timestamp": "2024-03-01T23:29:30.158Z",
+  "plan": {
+    "id": "867ed450-f6a8-4d33-9b0e-e11c5513990b",
+    "version": "2024-03-01T23:29:21.161Z",
+    "original": {"id": "/", "apps": [], "groups": [], "dependencies": [], "version": "2024-03-01T23:28:13.522Z"},
+    "target": {"id": "/", "apps": [], "groups": [], "dependencies": [], "version": "2024-03-01T23:29:21.161Z"},
+    "steps": [
+      {"actions": [{"action": "ScaleApplication", "app": "/marathon-lb"}]},
+      {"actions": [{"action": "RestartApplication", "app": "/other"}]}
+    ]
+  }
+}`
+
+func TestIntegrationDeploymentEvents(t *testing.T) {
+	tests := []struct {
+		eventType   string
+		wantID      int
+		wantWatched bool
+	}{
+		{eventType: marathonapi.DeploymentSuccessEvent, wantID: marathon.EventIDDeploymentSuccess, wantWatched: true},
+		{eventType: marathonapi.DeploymentFailedEvent, wantID: marathon.EventIDDeploymentFailed, wantWatched: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.eventType, func(t *testing.T) {
+			server := testutil.NewMockMarathonServer()
+			defer server.Close()
+			cfg := testConfig(t, server, "-watch-deployments")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			api := &marathonapi.API{Client: server.Client(), Host: server.URL, Hosts: []string{server.URL}, Path: "v2"}
+			events := make(marathon.EventsChannel, 1)
+			streamErrs := make(chan *marathonapi.StreamError, 1)
+			go api.StreamEvents(ctx, marathonapi.ReconnectPolicy{Delay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond}, events, streamErrs)
+			if !server.WaitForEventStreams(1, 5*time.Second) {
+				t.Fatal("The event stream didn't connect")
+			}
+
+			if err := server.FireEvent(test.eventType, json.RawMessage(fmt.Sprintf(deploymentEvent, test.eventType))); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case event := <-events:
+				if event.ID != test.wantID {
+					t.Errorf("Expected event id %d, got %d", test.wantID, event.ID)
+				}
+				if appIds := marathonapi.EventAppIds(event); !equalStrings(appIds, []string{"/marathon-lb", "/other"}) {
+					t.Errorf("Expected the apps of the deployment plan, got %v", appIds)
+				}
+				if watched := isWatchedEvent(event, newWatchedApps(cfg)); watched != test.wantWatched {
+					t.Errorf("Expected the event to trigger an update: %v, got %v", test.wantWatched, watched)
+				}
+			case err := <-streamErrs:
+				t.Fatalf("Event stream failed: %v", err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("No event received")
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"cirello.io/dynamolock"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// leaderLock prevents several updater instances from submitting conflicting changes by holding a
+// lock in a DynamoDB table for the duration of every update cycle. The instance that acquired the
+// lock for the last cycle is considered the leader.
+type leaderLock struct {
+	client   *dynamolock.Client
+	key      string
+	isLeader atomic.Bool
+}
+
+func newLeaderLock(tableName string, key string) (*leaderLock, error) {
+	sess := session.Must(session.NewSession())
+	client, err := dynamolock.New(dynamodb.New(sess), tableName,
+		dynamolock.WithLeaseDuration(30*time.Second),
+		dynamolock.WithHeartbeatPeriod(5*time.Second),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &leaderLock{client: client, key: key}, nil
+}
+
+// runAsLeader runs update while holding the lock. If another instance holds the lock update is
+// skipped and false is returned.
+func (l *leaderLock) runAsLeader(update func()) bool {
+	lock, err := l.client.AcquireLock(l.key, dynamolock.FailIfLocked())
+	if err != nil {
+		l.isLeader.Store(false)
+		if _, ok := err.(*dynamolock.LockNotGrantedError); ok {
+			log.Printf("Lock %s is held by another instance, skipping update", l.key)
+		} else {
+			log.Printf("WARNING: Unable to acquire lock %s, skipping update: %v", l.key, err)
+		}
+		return false
+	}
+	l.isLeader.Store(true)
+
+	defer func() {
+		if _, err := l.client.ReleaseLock(lock); err != nil {
+			log.Printf("WARNING: Unable to release lock %s: %v", l.key, err)
+		}
+	}()
+
+	update()
+	return true
+}
+
+func (l *leaderLock) String() string {
+	return fmt.Sprintf("leader=%v", l.isLeader.Load())
+}
+
+func (l *leaderLock) Close() error {
+	return l.client.Close()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+)
+
+const (
+	// TEXT_TIME_FORMAT matches the timestamps of the standard log package
+	TEXT_TIME_FORMAT = "2006/01/02 15:04:05 "
+)
+
+// levelPrefixes maps the prefixes of log messages to their level, messages without one are info
+var levelPrefixes = []struct {
+	prefix string
+	level  string
+}{
+	{"DEBUG: ", "debug"},
+	{"WARNING: ", "warn"},
+	{"ERROR: ", "error"},
+	{"FATAL: ", "fatal"},
+}
+
+// logger writes log lines either as plain text or as JSON objects, dropping those below its level.
+// It is installed as the output of the standard log package so every log.Printf call goes through it.
+type logger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	json  bool
+	level int
+	now   func() time.Time
+}
+
+var appLog = &logger{out: os.Stderr, level: config.LogLevels["info"], now: time.Now}
+
+// newLogger creates a logger writing the messages of at least level to out in the given format,
+// text or json
+func newLogger(out io.Writer, format string, level string) (*logger, error) {
+	minLevel, ok := config.LogLevels[level]
+	if !ok || level == "fatal" {
+		return nil, fmt.Errorf("Unknown log-level %q", level)
+	}
+	switch format {
+	case config.LOG_FORMAT_TEXT:
+		return &logger{out: out, level: minLevel, now: time.Now}, nil
+	case config.LOG_FORMAT_JSON:
+		return &logger{out: out, json: true, level: minLevel, now: time.Now}, nil
+	default:
+		return nil, fmt.Errorf("Unknown log-format %q", format)
+	}
+}
+
+// install makes l the output of the standard log package. The timestamp is written by l, so that
+// the level prefix of a message is at its start.
+func (l *logger) install() {
+	log.SetFlags(0)
+	log.SetOutput(l)
+}
+
+// enabled reports whether messages of level are written
+func (l *logger) enabled(level string) bool {
+	return config.LogLevels[level] >= l.level
+}
+
+// Write implements io.Writer for the standard log package, every call is a single log message
+func (l *logger) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	level := "info"
+	for _, lp := range levelPrefixes {
+		if strings.HasPrefix(msg, lp.prefix) {
+			level = lp.level
+			msg = strings.TrimPrefix(msg, lp.prefix)
+			break
+		}
+	}
+	if !l.enabled(level) {
+		return len(p), nil
+	}
+
+	if !l.json {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if _, err := io.WriteString(l.out, l.now().Format(TEXT_TIME_FORMAT)); err != nil {
+			return 0, err
+		}
+		return l.out.Write(p)
+	}
+
+	if err := l.writeJSON(level, msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// log writes msg at level with the given key value pairs. Errors are written as their message.
+func (l *logger) log(level, msg string, keysAndValues ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	if !l.json {
+		var buf bytes.Buffer
+		for _, lp := range levelPrefixes {
+			if lp.level == level {
+				buf.WriteString(lp.prefix)
+			}
+		}
+		buf.WriteString(msg)
+		for idx := 0; idx+1 < len(keysAndValues); idx += 2 {
+			fmt.Fprintf(&buf, " %v=%v", keysAndValues[idx], keysAndValues[idx+1])
+		}
+		log.Print(buf.String())
+		return
+	}
+
+	if err := l.writeJSON(level, msg, keysAndValues...); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write log message %q: %v\n", msg, err)
+	}
+}
+
+func (l *logger) writeJSON(level, msg string, keysAndValues ...interface{}) error {
+	entry := map[string]interface{}{
+		"level": level,
+		"ts":    l.now().UTC().Format(time.RFC3339Nano),
+		"msg":   msg,
+	}
+	for idx := 0; idx+1 < len(keysAndValues); idx += 2 {
+		value := keysAndValues[idx+1]
+		if v, ok := value.(error); ok {
+			value = v.Error()
+		}
+		entry[fmt.Sprint(keysAndValues[idx])] = value
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.out.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,1190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+	"github.com/DigDug101/marathon-dns-updater/internal/dns"
+	marathonapi "github.com/DigDug101/marathon-dns-updater/internal/marathon"
+	r53 "github.com/DigDug101/marathon-dns-updater/internal/route53"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/route53"
+	marathon "github.com/gambol99/go-marathon"
+)
+
+const MAX_COMMENT_LENGTH = 256
+
+type appError struct {
+	Err     error
+	IsFatal bool
+}
+
+func (e *appError) Error() string {
+	return e.Err.Error()
+}
+
+// updateRecords syncs the record sets for a single marathon-lb app with its running tasks. Once ctx
+// is cancelled no new changes are submitted, but changes already in flight are waited for. An update
+// still running after update-timeout is given up on with a non-fatal error.
+func updateRecords(ctx context.Context, cfg config.Config, client MarathonClient, provider dns.DNSProvider, target config.AppRecordSet) *appError {
+	updateCtx, cancel := context.WithTimeout(ctx, cfg.UpdateTimeout)
+	defer cancel()
+
+	phase := "fetching the marathon app"
+	appErr := updateAppRecords(updateCtx, cfg, client, provider, target, &phase)
+	if updateCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		appMetrics.updateTimeouts.Inc()
+		return &appError{
+			Err:     fmt.Errorf("Update of %s timed out after %v while %s", target.RecordSet, cfg.UpdateTimeout, phase),
+			IsFatal: false,
+		}
+	}
+	return appErr
+}
+
+// updateAppRecords does the work of updateRecords, keeping phase up to date with what it is doing
+func updateAppRecords(ctx context.Context, cfg config.Config, client MarathonClient, provider dns.DNSProvider, target config.AppRecordSet, phase *string) *appError {
+	appID, recordSet := target.AppID, target.RecordSet
+	cfg = cfg.ForTarget(target)
+
+	// Fetch running marathon-lb tasks
+	app, err := fetchApplication(ctx, client, appID)
+	if err != nil {
+		appMetrics.marathonFetchErrors.Inc()
+		msg := fmt.Sprintf("Unable to fetch appId: %s from host: %s, reason: %v", appID, strings.Join(cfg.MarathonHosts, ","), err)
+		// Keep the records of the last successful update rather than exiting
+		if state, ok := lastKnownState.get(appID); ok {
+			log.Printf("WARNING: %s, using cached state with %d IPs", msg, len(state.IPs)+len(state.IPv6s))
+			return &appError{
+				Err:     errors.New(msg),
+				IsFatal: false,
+			}
+		}
+		return &appError{
+			Err:     errors.New(msg),
+			IsFatal: true,
+		}
+	}
+
+	taskIps := make(map[string]string)
+	taskIpv6s := make(map[string]string)
+	var srvTargets []srvTarget
+	taskMetadataByIp := map[string]taskMetadata{}
+	// The label filters apply to the app, so either all of its tasks are included or none
+	tasks := app.Tasks
+	if !config.MatchesLabels(cfg.FilterLabels, app.Labels) {
+		log.Printf("WARNING: Skipping the tasks of appId: %s, its labels don't match filter-label", appID)
+		tasks = nil
+	}
+	excludedUnhealthy := 0
+	mesosNames := map[string]string{}
+	for _, task := range tasks {
+		log.Printf("DEBUG: Processing task: %v", task.ID)
+		if task.State != marathonapi.TaskRunning {
+			continue
+		}
+		if check := failingHealthCheck(task, cfg.MinConsecutiveFailures); check != nil {
+			log.Printf("WARNING: Excluding unhealthy task: %v, consecutive failures: %d", task.ID, check.ConsecutiveFailures)
+			excludedUnhealthy++
+			continue
+		}
+
+		if task.Host != "" && len(task.Ports) > 0 {
+			srvTargets = append(srvTargets, srvTarget{Host: task.Host, Ports: task.Ports})
+		}
+
+		if cfg.UseMesosDNS {
+			mesosNames[mesosDNSName(task)] = mesosDNSName(task)
+		}
+
+		// With record-value=host the records point at the hosts of the tasks, which take the place of
+		// the IPv4 addresses from here on
+		if cfg.RecordValue == config.RECORD_VALUE_HOST {
+			if task.Host == "" {
+				log.Printf("WARNING: Excluding task without host: %v", task.ID)
+				continue
+			}
+			taskMetadataByIp[task.Host] = taskMetadata{TaskID: task.ID, Version: task.Version, StagedAt: task.StagedAt}
+			taskIps[task.Host] = task.Host
+			continue
+		}
+
+		// With record-type aaaa-only the IPv6 addresses take the place of the IPv4 addresses from here
+		// on, so the records are named, numbered and weighted like A records
+		for _, ip := range task.IPAddresses {
+			taskMetadataByIp[ip.IPAddress] = taskMetadata{TaskID: task.ID, Version: task.Version, StagedAt: task.StagedAt}
+			switch {
+			case cfg.RecordType == config.RECORD_TYPE_AAAA_ONLY:
+				if ip.Protocol == "IPv6" {
+					taskIps[ip.IPAddress] = ip.IPAddress
+				}
+			case ip.Protocol == "IPv4":
+				taskIps[ip.IPAddress] = ip.IPAddress
+			case ip.Protocol == "IPv6":
+				taskIpv6s[ip.IPAddress] = ip.IPAddress
+			}
+		}
+	}
+	currentStatus.recordExcludedUnhealthy(target, excludedUnhealthy)
+
+	// The Mesos DNS names take the place of the hosts of record-value=host, unless one of them doesn't
+	// resolve, as a CNAME record can't share its name with the A records of the fallback
+	if cfg.UseMesosDNS && len(mesosNames) > 0 {
+		*phase = "resolving the Mesos DNS names"
+		if err := resolveAll(ctx, mesosNames); err != nil {
+			log.Printf("WARNING: Falling back to the IPs of appId: %s, unable to resolve its Mesos DNS names: %v", appID, err)
+		} else {
+			cfg.RecordValue = config.RECORD_VALUE_HOST
+			taskIps, taskIpv6s = mesosNames, map[string]string{}
+		}
+	}
+
+	excluded, err := config.ExcludedNetworks(cfg)
+	if err != nil {
+		return &appError{
+			Err:     err,
+			IsFatal: false,
+		}
+	}
+	taskIps = excludeIps(taskIps, excluded)
+	taskIpv6s = excludeIps(taskIpv6s, excluded)
+	healthyIps := len(taskIps)
+
+	warming := 0
+	if cfg.TaskWarmupDelay > 0 {
+		published := publishedIps(target)
+		var warmingIpv6s int
+		taskIps, warming = tasksWarmup.filter(statusKey(target)+" "+route53.RRTypeA, taskIps, published, cfg.TaskWarmupDelay)
+		taskIpv6s, warmingIpv6s = tasksWarmup.filter(statusKey(target)+" "+route53.RRTypeAaaa, taskIpv6s, published, cfg.TaskWarmupDelay)
+		warming += warmingIpv6s
+	}
+
+	if cfg.MaxIPs > 0 {
+		previous := lastPublishedState(target)
+		taskIps = limitIps(taskIps, cfg.MaxIPs, previous.IPs, cfg.PreferExisting)
+		taskIpv6s = limitIps(taskIpv6s, cfg.MaxIPs, previous.IPv6s, cfg.PreferExisting)
+	}
+
+	if len(taskIps) == 0 && warming > 0 {
+		log.Printf("Skipping update of %s, all %d tasks of appId: %s are warming up", recordSet, warming, appID)
+		return nil
+	}
+	// if we can't find any running tasks at all for this app something is probably wrong
+	if len(taskIps) == 0 {
+		if cfg.ScaleDownGuard {
+			return guardScaleDown(ctx, cfg, provider, target)
+		}
+		return &appError{
+			Err:     errors.New(fmt.Sprintf("No running tasks found for appId: %s", appID)),
+			IsFatal: true,
+		}
+	}
+	clearScaleDown(target)
+
+	// Replacing the records while too few tasks are healthy, e.g. mid-deployment, could leave the
+	// remaining tasks overloaded, so the records are left as they are until more tasks are healthy
+	if healthyIps < cfg.MinHealthyTasks {
+		log.Printf("WARNING: Skipping update of %s, only %d healthy tasks of appId: %s, min-healthy-tasks is %d",
+			recordSet, healthyIps, appID, cfg.MinHealthyTasks)
+		return nil
+	}
+
+	if cfg.AdaptiveTTL {
+		ttl := adaptiveTTL(cfg, target, taskIps, taskIpv6s)
+		cfg.WeightedTTL, cfg.EnumeratedTTL = ttl, ttl
+	}
+
+	// Ensure records for running tasks
+	weight := recordWeight(cfg, app)
+	recordType := route53.RRTypeA
+	if cfg.RecordValue == config.RECORD_VALUE_HOST {
+		// Host names can only be the value of CNAME records
+		recordType = route53.RRTypeCname
+	} else if cfg.RecordType == config.RECORD_TYPE_AAAA_ONLY {
+		recordType = route53.RRTypeAaaa
+	}
+	upserts, appErr := recordChanges(cfg, recordSet, sortedIps(taskIps), recordType, weight,
+		cfg.RecordSetTypes[config.WEIGHTED], cfg.RecordSetTypes[config.ENUMERATED])
+	if appErr != nil {
+		return appErr
+	}
+
+	ipv6Upserts, appErr := recordChanges(cfg, recordSet, sortedIps(taskIpv6s), route53.RRTypeAaaa, weight,
+		cfg.RecordSetTypes[config.WEIGHTED_IPV6], cfg.RecordSetTypes[config.ENUMERATED_IPV6])
+	if appErr != nil {
+		return appErr
+	}
+	upserts = append(upserts, ipv6Upserts...)
+
+	if cfg.RecordSetTypes[config.FAILOVER_PRIMARY] || cfg.RecordSetTypes[config.FAILOVER_SECONDARY] {
+		upserts = append(upserts, failoverChanges(cfg, recordSet, sortedIps(taskIps), recordType)...)
+	}
+
+	if cfg.RecordSetTypes[config.LATENCY] {
+		upserts = append(upserts, latencyChanges(cfg, recordSet, sortedIps(taskIps), recordType)...)
+	}
+
+	if cfg.RecordSetTypes[config.MULTIVALUE] {
+		upserts = append(upserts, multivalueChanges(cfg, recordSet, sortedIps(taskIps), recordType)...)
+	}
+
+	if cfg.RecordSetTypes[config.GEO] {
+		upserts = append(upserts, geoChanges(cfg, recordSet, sortedIps(taskIps), recordType)...)
+	}
+
+	if cfg.RecordSetTypes[config.SRV] {
+		srvUpserts, appErr := srvChanges(cfg, recordSet, srvTargets)
+		if appErr != nil {
+			return appErr
+		}
+		upserts = append(upserts, srvUpserts...)
+	}
+
+	if cfg.CreateTXTRecords {
+		upserts = append(upserts, txtChanges(upserts, taskMetadataByIp)...)
+	}
+
+	if ctx.Err() != nil {
+		log.Printf("Skipping update of %s: %v", recordSet, ctx.Err())
+		return nil
+	}
+
+	// Providers other than Route53 apply the same records one by one
+	r53Provider, ok := provider.(*r53.Provider)
+	if !ok {
+		*phase = "syncing the records"
+		if appErr := syncRecords(provider, recordSet, cfg.AAAAEnumeratedPrefix, upserts, publishedIps(target), cfg.DryRun); appErr != nil {
+			return appErr
+		}
+		if !cfg.DryRun {
+			notifyUpdate(cfg, target, taskIps, taskIpv6s)
+			recordSuccessfulUpdate(cfg, target, taskIps, taskIpv6s)
+		}
+		return nil
+	}
+
+	// Update Route53
+	r53Client := r53Provider.Client
+	var changes []*route53.Change
+
+	var orphanedHealthChecks []string
+	if (cfg.CreateHealthChecks || cfg.RecordSetTypes[config.FAILOVER_PRIMARY]) && !cfg.DryRun {
+		*phase = "ensuring the health checks"
+		var checkedIps []string
+		if cfg.RecordSetTypes[config.WEIGHTED] || cfg.RecordSetTypes[config.LATENCY] || cfg.RecordSetTypes[config.MULTIVALUE] || cfg.RecordSetTypes[config.FAILOVER_PRIMARY] {
+			checkedIps = append(checkedIps, sortedIps(taskIps)...)
+		}
+		if cfg.RecordSetTypes[config.WEIGHTED_IPV6] && cfg.CreateHealthChecks {
+			checkedIps = append(checkedIps, sortedIps(taskIpv6s)...)
+		}
+		healthCheckIds, orphaned, err := r53Provider.EnsureHealthChecks(ctx, cfg, recordSet, checkedIps)
+		if err != nil {
+			appMetrics.route53APIErrors.WithLabelValues(r53.ErrorCode(err)).Inc()
+			return &appError{
+				Err:     err,
+				IsFatal: false,
+			}
+		}
+		r53.SetHealthCheckIds(upserts, healthCheckIds)
+		orphanedHealthChecks = orphaned
+
+		// The primary record points at every task IP, so it fails over once all of them are unhealthy
+		if cfg.RecordSetTypes[config.FAILOVER_PRIMARY] {
+			var children []string
+			for _, ip := range sortedIps(taskIps) {
+				children = append(children, healthCheckIds[ip])
+			}
+			healthCheckId, orphaned, err := r53Provider.EnsureCalculatedHealthCheck(ctx, cfg, recordSet, children)
+			if err != nil {
+				appMetrics.route53APIErrors.WithLabelValues(r53.ErrorCode(err)).Inc()
+				return &appError{
+					Err:     err,
+					IsFatal: false,
+				}
+			}
+			r53.SetFailoverHealthCheckId(upserts, healthCheckId)
+			// A health check can't be deleted while a calculated health check refers to it
+			orphanedHealthChecks = append(orphaned, orphanedHealthChecks...)
+		} else {
+			// Left behind once failover-primary is no longer configured
+			calculated, err := r53Provider.CalculatedHealthChecks(ctx, recordSet)
+			if err != nil {
+				appMetrics.route53APIErrors.WithLabelValues(r53.ErrorCode(err)).Inc()
+				return &appError{
+					Err:     fmt.Errorf("Unable to list health checks: %v", err),
+					IsFatal: false,
+				}
+			}
+			var orphaned []string
+			for _, check := range calculated {
+				orphaned = append(orphaned, *check.HealthCheck.Id)
+			}
+			orphanedHealthChecks = append(orphaned, orphanedHealthChecks...)
+		}
+	}
+
+	// Delete out of date records
+	*phase = "listing the record sets"
+	recordSets, err := r53Provider.ListRecordSets(ctx, cfg.HostedZoneID, recordSet)
+	if err != nil {
+		appMetrics.route53APIErrors.WithLabelValues(r53.ErrorCode(err)).Inc()
+		return &appError{
+			Err:     fmt.Errorf("Unable to list record sets for %s: %v", recordSet, err),
+			IsFatal: false,
+		}
+	}
+
+	// A and AAAA record sets are checked against the task IPs of the matching protocol
+	ipsByRecordType := map[string]map[string]string{
+		route53.RRTypeA:    taskIps,
+		route53.RRTypeAaaa: taskIpv6s,
+	}
+	if cfg.RecordValue == config.RECORD_VALUE_HOST {
+		ipsByRecordType = map[string]map[string]string{route53.RRTypeCname: taskIps}
+	} else if cfg.RecordType == config.RECORD_TYPE_AAAA_ONLY {
+		ipsByRecordType = map[string]map[string]string{route53.RRTypeAaaa: taskIps}
+	}
+	// Other record sets, like SRV and CNAME, don't point at task IPs and are replaced by their upsert
+	// if there is one
+	upsertedRecordSets := map[string]bool{}
+	upsertedGeoSets := map[string]bool{}
+	for _, upsert := range upserts {
+		upsertedRecordSets[recordSetKey(upsert.ResourceRecordSet)] = true
+		if upsert.ResourceRecordSet.GeoLocation != nil {
+			upsertedGeoSets[recordSetKey(upsert.ResourceRecordSet)+" "+aws.StringValue(upsert.ResourceRecordSet.SetIdentifier)] = true
+		}
+	}
+	for _, existing := range recordSets {
+		// The listing may hold other names listed along with those of recordSet, other types at its
+		// names, e.g. TXT records for domain verification, and record sets created by others that
+		// point at a task IP, e.g. a weighted record set added by hand
+		if !isManagedRecordSet(cfg, recordSet, existing) {
+			continue
+		}
+		if ipsByRecordType[*existing.Type] == nil && upsertedRecordSets[recordSetKey(existing)] {
+			continue
+		}
+		// Geolocation record sets hold all task IPs and are replaced by their upsert, or deleted if
+		// their location is no longer configured
+		if existing.GeoLocation != nil {
+			if !upsertedGeoSets[recordSetKey(existing)+" "+aws.StringValue(existing.SetIdentifier)] {
+				log.Printf("Marking record set %s for deletion", existing.String())
+				changes = append(changes, &route53.Change{
+					Action:            aws.String(route53.ChangeActionDelete),
+					ResourceRecordSet: existing,
+				})
+			}
+			continue
+		}
+		// The primary failover record is replaced by its upsert and the static secondary is never deleted
+		if failover := aws.StringValue(existing.Failover); failover == route53.ResourceRecordSetFailoverSecondary ||
+			(failover == route53.ResourceRecordSetFailoverPrimary && cfg.RecordSetTypes[config.FAILOVER_PRIMARY]) {
+			continue
+		}
+		// Weighted records pointing at task IPs are replaced by the alias record when one is configured
+		replacedByAlias := cfg.AliasTarget != "" && existing.SetIdentifier != nil &&
+			strings.HasPrefix(*existing.SetIdentifier, "weighted-") && *existing.SetIdentifier != ALIAS_SET_IDENTIFIER
+		// Enumerated records of running tasks are replaced by their upsert, those left over under
+		// another name, e.g. AAAA records numbered like the A records, are deleted
+		staleEnumerated := existing.SetIdentifier == nil && !upsertedRecordSets[recordSetKey(existing)] &&
+			!strings.EqualFold(strings.TrimSuffix(*existing.Name, "."), strings.TrimSuffix(recordSet, "."))
+		if len(existing.ResourceRecords) > 0 {
+			record := existing.ResourceRecords[0]
+			if replacedByAlias || staleEnumerated || ipsByRecordType[*existing.Type][*record.Value] == "" {
+				log.Printf("Marking record set %s for deletion", existing.String())
+				recordDelete := &route53.Change{
+					Action:            aws.String(route53.ChangeActionDelete),
+					ResourceRecordSet: existing,
+				}
+
+				changes = append(changes, recordDelete)
+			}
+		}
+	}
+
+	// Upserts of record sets that are already up to date would be no-ops
+	existingRecordSets := map[string]*route53.ResourceRecordSet{}
+	for _, existing := range recordSets {
+		existingRecordSets[recordSetKey(existing)+" "+aws.StringValue(existing.SetIdentifier)] = existing
+	}
+	// Record sets of IPs that were published before but are missing now, e.g. deleted by hand, are
+	// backfilled by their upsert
+	published := publishedIps(target)
+	backfilled := 0
+	for _, upsert := range upserts {
+		existing := existingRecordSets[recordSetKey(upsert.ResourceRecordSet)+" "+aws.StringValue(upsert.ResourceRecordSet.SetIdentifier)]
+		if existing != nil && sameRecordSet(existing, upsert.ResourceRecordSet) {
+			continue
+		}
+		if existing == nil && isBackfill(upsert.ResourceRecordSet, published) {
+			log.Printf("WARNING: Backfilling record set %s, which is missing although it was published", upsert.ResourceRecordSet)
+			backfilled++
+		}
+		changes = append(changes, upsert)
+	}
+
+	// Route53 rejects empty change batches
+	if len(changes) == 0 {
+		log.Printf("No changes required for %s", recordSet)
+		appMetrics.updatesSkippedNoop.Inc()
+		if !cfg.DryRun {
+			// No record refers to the orphaned health checks, otherwise it would have been changed
+			r53Provider.DeleteHealthChecks(ctx, orphanedHealthChecks)
+			recordSuccessfulUpdate(cfg, target, taskIps, taskIpv6s)
+		}
+		return nil
+	}
+
+	if cfg.PlanOutput != "" {
+		plan := changePlan{
+			Timestamp:    time.Now().UTC(),
+			AppID:        appID,
+			HostedZoneID: cfg.HostedZoneID,
+			Changes:      changes,
+		}
+		if err := writePlan(cfg.PlanOutput, plan); err != nil {
+			return &appError{
+				Err:     fmt.Errorf("Unable to write change plan to %s: %v", cfg.PlanOutput, err),
+				IsFatal: false,
+			}
+		}
+	}
+
+	if cfg.DryRun {
+		for _, change := range changes {
+			logPlannedChange(change)
+		}
+		log.Printf("Dry run, not applying %d changes for %s", len(changes), recordSet)
+		return nil
+	}
+
+	comment := changeComment(cfg, target, taskIps, taskIpv6s)
+	changeInput := &route53.ChangeResourceRecordSetsInput{
+		ChangeBatch: &route53.ChangeBatch{
+			Changes: changes,
+			Comment: aws.String(comment),
+		},
+		HostedZoneId: aws.String(cfg.HostedZoneID),
+	}
+
+	// Start transaction
+	*phase = "submitting the changes"
+	release, err := r53Provider.Throttle(ctx)
+	if err != nil {
+		return &appError{
+			Err:     err,
+			IsFatal: false,
+		}
+	}
+	result, err := r53Client.ChangeResourceRecordSetsWithContext(ctx, changeInput)
+	release()
+	if err != nil {
+		appMetrics.route53APIErrors.WithLabelValues(r53.ErrorCode(err)).Inc()
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case route53.ErrCodeNoSuchHostedZone:
+				log.Println(route53.ErrCodeNoSuchHostedZone, aerr.Error())
+			case route53.ErrCodeNoSuchHealthCheck:
+				log.Println(route53.ErrCodeNoSuchHealthCheck, aerr.Error())
+				// The health check was deleted by other means, the retry lists them again
+				r53Provider.ResetHealthChecks()
+			case route53.ErrCodeInvalidChangeBatch:
+				log.Println(route53.ErrCodeInvalidChangeBatch, aerr.Error())
+			case route53.ErrCodeInvalidInput:
+				log.Println(route53.ErrCodeInvalidInput, aerr.Error())
+			case route53.ErrCodePriorRequestNotComplete:
+				log.Println(route53.ErrCodePriorRequestNotComplete, aerr.Error())
+			default:
+				log.Println(aerr.Error())
+			}
+		} else {
+			log.Println(err.Error())
+		}
+
+		return &appError{
+			Err:     err,
+			IsFatal: false,
+		}
+	}
+
+	appMetrics.recordsBackfilled.Add(float64(backfilled))
+	appliedChanges.Add(int64(len(changes)))
+
+	if cfg.AuditLog != "" {
+		_, added, removed := publishedChanges(target, taskIps, taskIpv6s)
+		auditChange(cfg, auditEntry{
+			ChangeID:   aws.StringValue(result.ChangeInfo.Id),
+			AppID:      appID,
+			RecordSet:  recordSet,
+			AddedIPs:   added,
+			RemovedIPs: removed,
+			Comment:    comment,
+		})
+	}
+
+	// Wait for transaction to complete
+	waitInput := &route53.GetChangeInput{
+		Id: result.ChangeInfo.Id,
+	}
+	// The change is applied eventually even if we stop waiting for it, so a timeout only warns
+	*phase = "waiting for the changes to be applied"
+	waitCtx, cancel := context.WithTimeout(ctx, cfg.Route53WaitTimeout)
+	err = r53Client.WaitUntilResourceRecordSetsChangedWithContext(waitCtx, waitInput)
+	cancel()
+
+	if waitCtx.Err() == context.DeadlineExceeded {
+		log.Printf("WARNING: Change %s for %s is still pending after route53-wait-timeout %v", *result.ChangeInfo.Id, recordSet, cfg.Route53WaitTimeout)
+	} else if err != nil {
+		log.Printf("Error updating record set: %v", err)
+	} else {
+		log.Printf("Updated record set for %s successfully, %d changes.", recordSet, len(changes))
+		// The health checks of deleted records are only removed once the records are gone
+		r53Provider.DeleteHealthChecks(ctx, orphanedHealthChecks)
+		notifyUpdate(cfg, target, taskIps, taskIpv6s)
+	}
+	recordSuccessfulUpdate(cfg, target, taskIps, taskIpv6s)
+
+	return nil
+}
+
+// publishedIps returns the IPs the records of target pointed at after its last successful update
+func publishedIps(target config.AppRecordSet) map[string]bool {
+	state := lastPublishedState(target)
+	published := map[string]bool{}
+	for _, ip := range append(append([]string{}, state.IPs...), state.IPv6s...) {
+		published[ip] = true
+	}
+	return published
+}
+
+// isBackfill reports whether the single value record set recordSet points at one of the published IPs
+func isBackfill(recordSet *route53.ResourceRecordSet, published map[string]bool) bool {
+	return len(recordSet.ResourceRecords) == 1 && published[aws.StringValue(recordSet.ResourceRecords[0].Value)]
+}
+
+// fetchApplication fetches the app appID from Marathon, giving up once ctx is done. The go-marathon
+// client doesn't take a context, so the request itself runs on in the background until it returns.
+func fetchApplication(ctx context.Context, client MarathonClient, appID string) (*marathon.Application, error) {
+	type result struct {
+		app *marathon.Application
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		app, err := client.Application(appID)
+		done <- result{app, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-done:
+		return r.app, r.err
+	}
+}
+
+// successfulUpdates counts the successful updates of the records of every app since the start
+var successfulUpdates atomic.Int64
+
+// recordSuccessfulUpdate records the IPs of a successful update in the status served by /status and
+// in the state file, if there is one
+func recordSuccessfulUpdate(cfg config.Config, target config.AppRecordSet, taskIps map[string]string, taskIpv6s map[string]string) {
+	successfulUpdates.Add(1)
+	recordStateDiff(target, taskIps, taskIpv6s)
+	currentStatus.recordUpdate(cfg, target, taskIps, taskIpv6s)
+
+	state := appState{
+		IPs:   sortedIps(taskIps),
+		IPv6s: sortedIps(taskIpv6s),
+	}
+	if err := lastKnownState.set(target.AppID, state); err != nil {
+		log.Printf("WARNING: Unable to write state file: %v", err)
+	}
+}
+
+// recordStateDiff logs the IPs a successful update added to and removed from the records of target and
+// exposes their numbers as metrics. It has to be called before the update is recorded, as the IPs of
+// the previous update are the baseline.
+func recordStateDiff(target config.AppRecordSet, taskIps map[string]string, taskIpv6s map[string]string) {
+	current, added, removed := publishedChanges(target, taskIps, taskIpv6s)
+
+	appMetrics.recordsActive.WithLabelValues(target.AppID, target.RecordSet).Set(float64(len(current)))
+	appMetrics.recordsAdded.WithLabelValues(target.AppID, target.RecordSet).Set(float64(len(added)))
+	appMetrics.recordsRemoved.WithLabelValues(target.AppID, target.RecordSet).Set(float64(len(removed)))
+
+	if len(added) > 0 || len(removed) > 0 {
+		log.Printf("Records of %s now point at %d IPs, added %d %v, removed %d %v",
+			target.RecordSet, len(current), len(added), added, len(removed), removed)
+	}
+}
+
+// publishedChanges returns the IPs of taskIps and taskIpv6s and those of them added and removed since
+// the last successful update of target
+func publishedChanges(target config.AppRecordSet, taskIps map[string]string, taskIpv6s map[string]string) (current []string, added []string, removed []string) {
+	state := lastPublishedState(target)
+	previous := append(append([]string{}, state.IPs...), state.IPv6s...)
+	current = append(sortedIps(taskIps), sortedIps(taskIpv6s)...)
+	return current, missingIps(current, previous), missingIps(previous, current)
+}
+
+// lastPublishedState returns the IPs the records of target pointed at after its last successful
+// update, falling back to the state file after a restart
+func lastPublishedState(target config.AppRecordSet) appState {
+	if state, ok := currentStatus.lastState(target); ok {
+		return state
+	}
+	state, _ := lastKnownState.get(target.AppID)
+	return state
+}
+
+// changeComment renders the record-set-comment template for the change batch of target
+func changeComment(cfg config.Config, target config.AppRecordSet, taskIps map[string]string, taskIpv6s map[string]string) string {
+	hostName, _ := os.Hostname()
+	current, added, removed := publishedChanges(target, taskIps, taskIpv6s)
+	data := config.ChangeCommentData{
+		RecordSet:    target.RecordSet,
+		AppID:        target.AppID,
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+		HostName:     hostName,
+		TaskCount:    len(current),
+		AddedCount:   len(added),
+		RemovedCount: len(removed),
+		// The update is counted once it has succeeded
+		CycleNumber: successfulUpdates.Load() + 1,
+		GoVersion:   runtime.Version(),
+	}
+
+	var comment strings.Builder
+	if err := cfg.RecordSetComment.Execute(&comment, data); err != nil {
+		log.Printf("WARNING: Unable to render record-set-comment: %v", err)
+		return fmt.Sprintf("Updated records for %s", target.RecordSet)
+	}
+	// Route53 rejects comments longer than 256 characters
+	if comment.Len() > MAX_COMMENT_LENGTH {
+		return comment.String()[:MAX_COMMENT_LENGTH]
+	}
+	return comment.String()
+}
+
+// failingHealthCheck returns the first health check result of task that has failed at least
+// minConsecutiveFailures times in a row, or nil if the task is healthy or the check is disabled
+func failingHealthCheck(task *marathon.Task, minConsecutiveFailures int) *marathon.HealthCheckResult {
+	if minConsecutiveFailures <= 0 {
+		return nil
+	}
+
+	for _, check := range task.HealthCheckResults {
+		if check != nil && !check.Alive && check.ConsecutiveFailures >= minConsecutiveFailures {
+			return check
+		}
+	}
+	return nil
+}
+
+// retryUpdate calls update until it succeeds or returns a fatal error, retrying non-fatal errors up
+// to cfg.Route53MaxRetries times with an exponential back-off with jitter between attempts
+func retryUpdate(ctx context.Context, cfg config.Config, appID string, update func() *appError) *appError {
+	err := update()
+
+	attempt := 0
+	for ; err != nil && !err.IsFatal && attempt < cfg.Route53MaxRetries; attempt++ {
+		backoff := cfg.Route53BaseBackoff << uint(attempt)
+		backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		log.Printf("Retrying update of appId %s in %v (retry %d of %d): %v", appID, backoff, attempt+1, cfg.Route53MaxRetries, err)
+		appMetrics.updateRetries.Inc()
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		err = update()
+	}
+
+	if attempt > 0 {
+		if err != nil {
+			log.Printf("Update of appId %s failed after %d retries", appID, attempt)
+		} else {
+			log.Printf("Update of appId %s succeeded after %d retries", appID, attempt)
+		}
+	}
+	return err
+}
+
+// updateAllRecords runs updateRecords concurrently for every configured app so that a slow or
+// failing app doesn't hold up the others. The returned errors are indexed like cfg.AppRecordSets.
+func updateAllRecords(ctx context.Context, cfg config.Config, client MarathonClient, provider dns.DNSProvider) []*appError {
+	errs := make([]*appError, len(cfg.AppRecordSets))
+	var wg sync.WaitGroup
+	cycleStart := time.Now()
+	log.Printf("Updating the records of %d apps", len(cfg.AppRecordSets))
+	if r53Provider, ok := provider.(*r53.Provider); ok {
+		r53Provider.ResetHealthChecks()
+	}
+
+	for idx, target := range cfg.AppRecordSets {
+		wg.Add(1)
+		go func(idx int, target config.AppRecordSet) {
+			defer wg.Done()
+			start := time.Now()
+			errs[idx] = retryUpdate(ctx, cfg, target.AppID, func() *appError {
+				return updateRecords(ctx, cfg, client, provider, target)
+			})
+			appMetrics.updateDuration.Observe(time.Since(start).Seconds())
+			if errs[idx] != nil {
+				appMetrics.updateTotal.WithLabelValues("error").Inc()
+			} else {
+				appMetrics.updateTotal.WithLabelValues("success").Inc()
+			}
+		}(idx, target)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	log.Printf("Updated the records of %d apps in %v, %d failed", len(cfg.AppRecordSets)-failed, time.Since(cycleStart).Round(time.Millisecond), failed)
+
+	if cfg.AppIDRegex != nil {
+		removeVanishedApps(ctx, cfg, provider)
+	}
+	return errs
+}
+
+// updateCycle updates the records of every configured app, exiting if none of them could be updated
+func updateCycle(ctx context.Context, cfg config.Config, client MarathonClient, provider dns.DNSProvider) {
+	cfg, err := resolveApps(cfg, client)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return
+	}
+
+	fatalCount := 0
+	for idx, err := range updateAllRecords(ctx, cfg, client, provider) {
+		if err == nil {
+			continue
+		}
+		logAppError(cfg.AppRecordSets[idx].AppID, err)
+		if err.IsFatal {
+			fatalCount++
+		}
+	}
+	// A fatal error for one app must not stop the others from being updated, so we only give
+	// up when none of the apps can be updated
+	if fatalCount == len(cfg.AppRecordSets) {
+		log.Fatalf("FATAL: unable to update records for any app")
+	}
+}
+
+// logAppError logs the error of updating the records of appID, as an error if it is fatal and as a
+// warning otherwise
+func logAppError(appID string, err *appError) {
+	level := "warn"
+	if err.IsFatal {
+		level = "error"
+	}
+	appLog.log(level, "Unable to update records", "appId", appID, "error", err)
+}
+
+// runOnce updates the records of every configured app a single time and returns the exit code:
+// 0 on success, 1 if an app had a non-fatal error and 2 if an app had a fatal error
+func runOnce(ctx context.Context, cfg config.Config, client MarathonClient, provider dns.DNSProvider, leader *leaderLock) int {
+	cfg, err := resolveApps(cfg, client)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return 2
+	}
+
+	var errs []*appError
+	update := func() {
+		errs = updateAllRecords(ctx, cfg, client, provider)
+	}
+	if leader != nil {
+		leader.runAsLeader(update)
+	} else {
+		update()
+	}
+
+	exitCode := 0
+	for idx, err := range errs {
+		if err == nil {
+			continue
+		}
+		logAppError(cfg.AppRecordSets[idx].AppID, err)
+		if err.IsFatal {
+			exitCode = 2
+		} else if exitCode == 0 {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+// waitForEvent blocks until a status update or deployment success for one of the watched apps is
+// received, the event stream drops or poll fires. It returns the event that ended the wait, nil unless
+// it was received from the event stream, and false if ctx is cancelled first.
+func waitForEvent(ctx context.Context, events marathon.EventsChannel, streamErrs <-chan *marathonapi.StreamError, poll <-chan time.Time, watched watchedApps) (*marathon.Event, bool) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-poll:
+			log.Println("No update within the poll interval, polling")
+			return nil, true
+		case <-warmupDone:
+			log.Println("Tasks have warmed up, updating")
+			return nil, true
+		case err := <-streamErrs:
+			handleStreamError(err)
+			// Events may have been missed while the stream was down
+			return nil, true
+		case update := <-events:
+			if isWatchedEvent(update, watched) {
+				return update, true
+			}
+		}
+	}
+}
+
+// debounceEvents collects further events for the watched apps for up to the debounce window after
+// a triggering event so that a burst of events results in a single update. It returns early once
+// maxPending events are pending, and returns the number of pending events, including the pending
+// ones it started with, and false if ctx is cancelled.
+func debounceEvents(ctx context.Context, events marathon.EventsChannel, streamErrs <-chan *marathonapi.StreamError, watched watchedApps, debounce time.Duration, maxPending int, pending int) (int, bool) {
+	timer := time.NewTimer(debounce)
+	defer timer.Stop()
+
+	for pending < maxPending {
+		select {
+		case <-ctx.Done():
+			return pending, false
+		case <-timer.C:
+			log.Printf("DEBUG: Debounced %d events", pending)
+			return pending, true
+		case err := <-streamErrs:
+			handleStreamError(err)
+		case update := <-events:
+			if isWatchedEvent(update, watched) {
+				pending++
+			}
+		}
+	}
+
+	log.Printf("%d events pending, updating without waiting for the debounce window", pending)
+	return pending, true
+}
+
+// drainEvents takes the events that are already queued off the channel without waiting for more, so
+// that they are covered by the next update rather than each triggering another one. It takes at
+// most max events, so that a steady stream of events can't hold up the update, and returns the
+// number of events for the watched apps among them.
+func drainEvents(events marathon.EventsChannel, watched watchedApps, max int) int {
+	drained := 0
+	for taken := 0; taken < max; taken++ {
+		select {
+		case update := <-events:
+			if isWatchedEvent(update, watched) {
+				drained++
+			}
+		default:
+			return drained
+		}
+	}
+	return drained
+}
+
+// handleStreamError logs an error of the event stream, exiting if it is fatal
+func handleStreamError(err *marathonapi.StreamError) {
+	if err.IsFatal {
+		log.Fatalf("FATAL: %v", err)
+	}
+	log.Printf("WARNING: %v, updating records in case events were missed", err)
+}
+
+// isWatchedEvent reports whether an event is about one of the watched apps and should trigger an
+// update. Failed deployments never do, with watch-deployments they are logged as a warning.
+func isWatchedEvent(update *marathon.Event, watched watchedApps) bool {
+	for _, appID := range marathonapi.EventAppIds(update) {
+		if !watched.contains(appID) {
+			continue
+		}
+		if update.ID == marathon.EventIDDeploymentFailed {
+			if watched.deployments {
+				log.Printf("WARNING: Deployment of appId: %s failed, leaving its records as they are", appID)
+			}
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+func main() {
+	cfg, err := config.NewConfigFromFlags()
+	if err != nil {
+		log.Println(err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	appLog, err = newLogger(os.Stderr, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	appLog.install()
+	setCurrentConfig(cfg)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	watched := newWatchedApps(cfg)
+
+	var dnsProvider dns.DNSProvider
+	switch cfg.DNSProvider {
+	case config.ROUTE53:
+		awsConfig := aws.NewConfig()
+		if cfg.AssumeRoleArn != "" {
+			role, err := newAssumedRole(ctx, cfg.AssumeRoleArn, cfg.AssumeRoleSessionName)
+			if err != nil {
+				log.Fatalf("FATAL: Error assuming role %s: %v", cfg.AssumeRoleArn, err)
+			}
+			awsConfig = awsConfig.WithCredentials(credentials.NewCredentials(role))
+		}
+		if cfg.Route53EndpointURL != "" {
+			log.Printf("WARNING: Using the Route53 API at %s instead of AWS, route53-endpoint-url is meant for testing only", cfg.Route53EndpointURL)
+			// Route53 is a global service whose requests are signed for us-east-1
+			awsConfig = awsConfig.WithEndpoint(cfg.Route53EndpointURL).WithRegion(endpoints.UsEast1RegionID)
+		}
+		provider := r53.NewProvider(cfg.HostedZoneID, awsConfig, cfg.Route53RPS, cfg.ListConcurrency, cfg.Route53Concurrency)
+		if cfg.PrivateZone {
+			if err := provider.VerifyPrivateZones(config.HostedZoneIds(cfg)); err != nil {
+				log.Fatalf("FATAL: %v", err)
+			}
+		}
+		dnsProvider = provider
+	case config.CLOUDFLARE:
+		provider, err := newCloudflareProvider(cfg.CloudflareAPIToken, cfg.HostedZoneID)
+		if err != nil {
+			log.Fatalf("FATAL: Error creating cloudflare client: %v", err)
+		}
+		dnsProvider = provider
+	case config.GOOGLE:
+		provider, err := newGoogleProvider(cfg.GCPProject, cfg.GCPManagedZone)
+		if err != nil {
+			log.Fatalf("FATAL: Error creating Cloud DNS client: %v", err)
+		}
+		dnsProvider = provider
+	case config.AZURE:
+		provider, err := newAzureProvider(cfg.AzureSubscriptionID, cfg.AzureResourceGroup, cfg.AzureDNSZoneName)
+		if err != nil {
+			log.Fatalf("FATAL: Error creating Azure DNS client: %v", err)
+		}
+		dnsProvider = provider
+	case config.CONSUL:
+		provider, err := newConsulProvider(cfg.ConsulAddr, cfg.ConsulToken, cfg.ConsulDC)
+		if err != nil {
+			log.Fatalf("FATAL: Error creating Consul client: %v", err)
+		}
+		dnsProvider = provider
+	}
+
+	if cfg.StateFile != "" {
+		lastKnownState, err = loadStateFile(cfg.StateFile)
+		if err != nil {
+			log.Fatalf("FATAL: Error reading state file %s: %v", cfg.StateFile, err)
+		}
+	}
+
+	var leader *leaderLock
+	if cfg.DynamoDBLockTable != "" {
+		leader, err = newLeaderLock(cfg.DynamoDBLockTable, "marathon-dns-updater-"+cfg.HostedZoneID)
+		if err != nil {
+			log.Fatalf("FATAL: Error creating DynamoDB lock client: %v", err)
+		}
+		defer leader.Close()
+	}
+
+	transport, err := newMarathonTransport(cfg)
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	client := &http.Client{Transport: marathonAuthTransport(cfg, transport)}
+
+	marathonClient, err := newMarathonClient(cfg, client)
+	if err != nil {
+		log.Fatalf("FATAL: Error creating marathon client: %v", err)
+	}
+
+	// Run as a job, e.g. from cron, without the admin server or event subscription
+	if cfg.Once {
+		exitCode := runOnce(ctx, cfg, marathonClient, dnsProvider, leader)
+		pendingNotifications.Wait()
+		if leader != nil {
+			leader.Close()
+		}
+		os.Exit(exitCode)
+	}
+
+	// Marathon may still be starting, e.g. along with the rest of the cluster, and the first update
+	// would fail without it
+	if cfg.StartupRetryMaxWait > 0 {
+		if err := waitForMarathon(ctx, marathonClient, cfg.StartupRetryMaxWait); err != nil {
+			if ctx.Err() != nil {
+				log.Println("Received shutdown signal, stopping")
+				return
+			}
+			log.Fatalf("FATAL: %v", err)
+		}
+	}
+
+	eventsAPI := &marathonapi.API{
+		Client:    client,
+		Host:      cfg.MarathonHosts[0],
+		Hosts:     cfg.MarathonHosts,
+		Path:      "v2",
+		OnConnect: currentStatus.setMarathonHost,
+		LogEvent:  logEvent,
+	}
+	events := make(marathon.EventsChannel, cfg.MaxPendingEvents)
+	streamErrs := make(chan *marathonapi.StreamError, 1)
+	go func() {
+		policy := marathonapi.ReconnectPolicy{
+			Delay:       cfg.SSEReconnectDelay,
+			MaxDelay:    cfg.SSEMaxReconnectDelay,
+			MaxAttempts: cfg.SSEMaxReconnectAttempts,
+		}
+		eventsAPI.StreamEvents(ctx, policy, events, streamErrs)
+	}()
+
+	httpAddr := "0.0.0.0:" + cfg.AdminHTTPPort
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		ok, err := marathonClient.Ping()
+		if err != nil || !ok {
+			http.Error(w, "NOT OK", http.StatusServiceUnavailable)
+		} else {
+			fmt.Fprintln(w, "OK")
+			if leader != nil {
+				fmt.Fprintln(w, leader)
+			}
+		}
+	})
+	mux.Handle("/metrics", appMetrics.handler())
+	mux.Handle("/status", currentStatus.handler(cfg.HostedZoneID))
+	mux.Handle("/ready", currentStatus.readyHandler())
+	if cfg.UpdateSecret != "" {
+		mux.Handle("/update", triggerHandler(ctx, cfg, marathonClient, dnsProvider, leader))
+	}
+	if cfg.EnablePprof {
+		log.Printf("WARNING: pprof is enabled on /debug/pprof/, which exposes runtime data of the updater to anyone reaching the admin port")
+		registerPprof(mux)
+	}
+
+	var handler http.Handler = mux
+	if cfg.AdminUser != "" {
+		var public []string
+		if cfg.HealthEndpointPublic {
+			public = append(public, "/health")
+		}
+		handler = adminAuth(mux, cfg.AdminUser, cfg.AdminPassword, public...)
+	}
+
+	httpServer := &http.Server{
+		Addr:         httpAddr,
+		Handler:      handler,
+		ReadTimeout:  1000 * time.Millisecond,
+		WriteTimeout: 1000 * time.Millisecond,
+	}
+	httpServer.SetKeepAlivesEnabled(false)
+
+	// Start HTTP server in background
+	go func() {
+		log.Printf("Starting HTTPServer: addr=%v", httpAddr)
+		err := httpServer.ListenAndServe()
+		log.Printf("HTTPServer exited: err=%v", err)
+	}()
+
+	// update records on startup, unless startup-sync is disabled, and then only when we receive a
+	// status update or deployment success event for one of our apps. The event stream is connected
+	// before the startup sync so that events received while it runs are queued rather than missed.
+	update := cfg.StartupSync
+	if cfg.ConfigFile != "" {
+		go watchReloads(ctx)
+	}
+	if cfg.ForceResyncInterval > 0 {
+		go runResyncs(ctx, cfg, marathonClient, dnsProvider, leader)
+	}
+	// A nil poll channel never fires, so polling is disabled unless there's a poll interval
+	var poll <-chan time.Time
+	for {
+		if update {
+			updateLock.Lock()
+			if leader != nil {
+				leader.runAsLeader(func() {
+					updateCycle(ctx, currentConfig(), marathonClient, dnsProvider)
+				})
+			} else {
+				updateCycle(ctx, currentConfig(), marathonClient, dnsProvider)
+			}
+			updateLock.Unlock()
+
+			sleepDuration := 1 * time.Second // Sleep to prevent hammering the route53 api
+			select {
+			case <-ctx.Done():
+			case <-time.After(sleepDuration):
+			}
+		}
+		update = true
+
+		if cfg.PollInterval > 0 {
+			// Polls only happen when there has been no other update for the poll interval
+			poll = time.After(cfg.PollInterval)
+		}
+		trigger, ok := waitForEvent(ctx, events, streamErrs, poll, watched)
+		if !ok {
+			break
+		}
+		batch := 0
+		if trigger != nil {
+			batch = 1
+		}
+		// A successful deployment is complete, so there are no further events to wait for
+		if cfg.WatchDeployments && trigger != nil && trigger.ID == marathon.EventIDDeploymentSuccess {
+			log.Printf("Deployment succeeded, updating without waiting for the debounce window")
+		} else if batch, ok = debounceEvents(ctx, events, streamErrs, watched, cfg.Debounce, cfg.MaxPendingEvents, batch); !ok {
+			break
+		}
+		if batch < cfg.MaxEventBatch {
+			batch += drainEvents(events, watched, cfg.MaxEventBatch-batch)
+		}
+		log.Printf("Updating records for a batch of %d events", batch)
+	}
+
+	log.Println("Received shutdown signal, stopping")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down HTTPServer: %v", err)
+	}
+	log.Println("Exited cleanly")
+}
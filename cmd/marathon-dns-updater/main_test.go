@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+	r53 "github.com/DigDug101/marathon-dns-updater/internal/route53"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// mockRoute53 is a route53iface.Route53API holding the record sets of a single hosted zone in memory.
+// Only the calls of updateRecords are implemented, the others panic.
+type mockRoute53 struct {
+	route53iface.Route53API
+
+	mu         sync.Mutex
+	recordSets []*route53.ResourceRecordSet
+	// changeErr, if set, fails every change batch
+	changeErr error
+	// changeBatches holds the change batches submitted so far
+	changeBatches []*route53.ChangeBatch
+	// healthChecks holds the health checks by id along with their tags, deletedHealthChecks the ids
+	// of those deleted so far
+	healthChecks        map[string]*route53.HealthCheck
+	healthCheckTags     map[string][]*route53.Tag
+	deletedHealthChecks []string
+}
+
+func newMockRoute53(recordSets ...*route53.ResourceRecordSet) *mockRoute53 {
+	return &mockRoute53{recordSets: recordSets}
+}
+
+func (m *mockRoute53) ListResourceRecordSetsWithContext(ctx aws.Context, input *route53.ListResourceRecordSetsInput, opts ...request.Option) (*route53.ListResourceRecordSetsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	recordSets := append([]*route53.ResourceRecordSet(nil), m.recordSets...)
+	sort.SliceStable(recordSets, func(i, j int) bool {
+		return aws.StringValue(recordSets[i].Name) < aws.StringValue(recordSets[j].Name)
+	})
+	return &route53.ListResourceRecordSetsOutput{ResourceRecordSets: recordSets, IsTruncated: aws.Bool(false)}, nil
+}
+
+func (m *mockRoute53) ChangeResourceRecordSetsWithContext(ctx aws.Context, input *route53.ChangeResourceRecordSetsInput, opts ...request.Option) (*route53.ChangeResourceRecordSetsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.changeBatches = append(m.changeBatches, input.ChangeBatch)
+	if m.changeErr != nil {
+		return nil, m.changeErr
+	}
+
+	for _, change := range input.ChangeBatch.Changes {
+		idx := m.indexOf(change.ResourceRecordSet)
+		switch aws.StringValue(change.Action) {
+		case route53.ChangeActionDelete:
+			if idx < 0 {
+				return nil, awserr.New(route53.ErrCodeInvalidChangeBatch, fmt.Sprintf("record set %s not found", change.ResourceRecordSet), nil)
+			}
+			m.recordSets = append(m.recordSets[:idx], m.recordSets[idx+1:]...)
+		case route53.ChangeActionUpsert:
+			if idx >= 0 {
+				m.recordSets[idx] = change.ResourceRecordSet
+			} else {
+				m.recordSets = append(m.recordSets, change.ResourceRecordSet)
+			}
+		}
+	}
+	return &route53.ChangeResourceRecordSetsOutput{ChangeInfo: &route53.ChangeInfo{Id: aws.String(fmt.Sprintf("C%d", len(m.changeBatches)))}}, nil
+}
+
+func (m *mockRoute53) WaitUntilResourceRecordSetsChangedWithContext(ctx aws.Context, input *route53.GetChangeInput, opts ...request.WaiterOption) error {
+	return nil
+}
+
+func (m *mockRoute53) ListHealthChecksWithContext(ctx aws.Context, input *route53.ListHealthChecksInput, opts ...request.Option) (*route53.ListHealthChecksOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	output := &route53.ListHealthChecksOutput{IsTruncated: aws.Bool(false)}
+	for _, healthCheck := range m.healthChecks {
+		output.HealthChecks = append(output.HealthChecks, healthCheck)
+	}
+	return output, nil
+}
+
+func (m *mockRoute53) ListTagsForResourcesWithContext(ctx aws.Context, input *route53.ListTagsForResourcesInput, opts ...request.Option) (*route53.ListTagsForResourcesOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	output := &route53.ListTagsForResourcesOutput{}
+	for _, id := range input.ResourceIds {
+		output.ResourceTagSets = append(output.ResourceTagSets, &route53.ResourceTagSet{
+			ResourceId:   id,
+			ResourceType: input.ResourceType,
+			Tags:         m.healthCheckTags[aws.StringValue(id)],
+		})
+	}
+	return output, nil
+}
+
+func (m *mockRoute53) DeleteHealthCheckWithContext(ctx aws.Context, input *route53.DeleteHealthCheckInput, opts ...request.Option) (*route53.DeleteHealthCheckOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := aws.StringValue(input.HealthCheckId)
+	if _, ok := m.healthChecks[id]; !ok {
+		return nil, awserr.New(route53.ErrCodeNoSuchHealthCheck, "no health check "+id, nil)
+	}
+	delete(m.healthChecks, id)
+	m.deletedHealthChecks = append(m.deletedHealthChecks, id)
+	return &route53.DeleteHealthCheckOutput{}, nil
+}
+
+// addHealthCheck adds a health check of ip on port, tagged as created for marathon-lb.example.com
+func (m *mockRoute53) addHealthCheck(id string, ip string, port int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.healthChecks == nil {
+		m.healthChecks, m.healthCheckTags = map[string]*route53.HealthCheck{}, map[string][]*route53.Tag{}
+	}
+	m.healthChecks[id] = &route53.HealthCheck{
+		Id: aws.String(id),
+		HealthCheckConfig: &route53.HealthCheckConfig{
+			IPAddress:    aws.String(ip),
+			Port:         aws.Int64(port),
+			Type:         aws.String(route53.HealthCheckTypeHttp),
+			ResourcePath: aws.String("/"),
+		},
+	}
+	m.healthCheckTags[id] = []*route53.Tag{
+		{Key: aws.String(config.HEALTH_CHECK_MANAGED_BY_TAG), Value: aws.String(config.HEALTH_CHECK_MANAGED_BY)},
+		{Key: aws.String(config.HEALTH_CHECK_RECORD_SET_TAG), Value: aws.String("marathon-lb.example.com")},
+	}
+}
+
+// indexOf returns the index of the record set with the name, type and set identifier of recordSet,
+// or -1
+func (m *mockRoute53) indexOf(recordSet *route53.ResourceRecordSet) int {
+	for idx, existing := range m.recordSets {
+		if recordSetKey(existing) == recordSetKey(recordSet) && aws.StringValue(existing.SetIdentifier) == aws.StringValue(recordSet.SetIdentifier) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// records returns the record sets of the zone as "name type identifier value" strings, sorted
+func (m *mockRoute53) records() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var records []string
+	for _, recordSet := range m.recordSets {
+		for _, record := range recordSet.ResourceRecords {
+			records = append(records, strings.Join([]string{aws.StringValue(recordSet.Name), aws.StringValue(recordSet.Type),
+				aws.StringValue(recordSet.SetIdentifier), aws.StringValue(record.Value)}, " "))
+		}
+	}
+	sort.Strings(records)
+	return records
+}
+
+// newMockRoute53Provider returns a Route53 provider submitting its changes to client
+func newMockRoute53Provider(client route53iface.Route53API) *r53.Provider {
+	provider := r53.NewProvider("Z1", aws.NewConfig().WithRegion("us-east-1"), 100, 1, 1)
+	provider.Client = client
+	return provider
+}
+
+// weightedRecordSet is the weighted record set of ip as created by the updater
+func weightedRecordSet(ip string) *route53.ResourceRecordSet {
+	return &route53.ResourceRecordSet{
+		Name:            aws.String("marathon-lb.example.com"),
+		Type:            aws.String(route53.RRTypeA),
+		TTL:             aws.Int64(60),
+		Weight:          aws.Int64(DEFAULT_WEIGHT),
+		SetIdentifier:   aws.String("weighted-" + ip),
+		ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(ip)}},
+	}
+}
+
+// enumeratedRecordSet is the enumerated record set number idx of ip as created by the updater
+func enumeratedRecordSet(idx int, ip string) *route53.ResourceRecordSet {
+	return &route53.ResourceRecordSet{
+		Name:            aws.String(fmt.Sprintf("marathon-lb-%d.example.com", idx)),
+		Type:            aws.String(route53.RRTypeA),
+		TTL:             aws.Int64(60),
+		ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(ip)}},
+	}
+}
+
+func TestUpdateRecords(t *testing.T) {
+	tests := []struct {
+		name        string
+		existing    []*route53.ResourceRecordSet
+		ips         []string
+		changeErr   error
+		wantRecords []string
+		wantBatches int
+		wantErr     string
+	}{
+		{
+			name: "creates the records of new tasks",
+			ips:  []string{"10.0.0.2", "10.0.0.1"},
+			wantRecords: []string{
+				"marathon-lb-1.example.com A  10.0.0.1",
+				"marathon-lb-2.example.com A  10.0.0.2",
+				"marathon-lb.example.com A weighted-10.0.0.1 10.0.0.1",
+				"marathon-lb.example.com A weighted-10.0.0.2 10.0.0.2",
+			},
+			wantBatches: 1,
+		},
+		{
+			name:     "replaces the records of a stopped task",
+			existing: []*route53.ResourceRecordSet{weightedRecordSet("10.0.0.1"), weightedRecordSet("10.0.0.3"), enumeratedRecordSet(1, "10.0.0.1"), enumeratedRecordSet(2, "10.0.0.3")},
+			ips:      []string{"10.0.0.1", "10.0.0.2"},
+			wantRecords: []string{
+				"marathon-lb-1.example.com A  10.0.0.1",
+				"marathon-lb-2.example.com A  10.0.0.2",
+				"marathon-lb.example.com A weighted-10.0.0.1 10.0.0.1",
+				"marathon-lb.example.com A weighted-10.0.0.2 10.0.0.2",
+			},
+			wantBatches: 1,
+		},
+		{
+			name: "leaves the records of others alone",
+			existing: []*route53.ResourceRecordSet{
+				{
+					Name:            aws.String("marathon-lb.example.com"),
+					Type:            aws.String(route53.RRTypeA),
+					TTL:             aws.Int64(60),
+					Weight:          aws.Int64(10),
+					SetIdentifier:   aws.String("manual-10.0.0.9"),
+					ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.9")}},
+				},
+				{
+					Name:            aws.String("marathon-lb.example.com"),
+					Type:            aws.String(route53.RRTypeTxt),
+					TTL:             aws.Int64(300),
+					ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(`"verification"`)}},
+				},
+			},
+			ips: []string{"10.0.0.1"},
+			wantRecords: []string{
+				"marathon-lb-1.example.com A  10.0.0.1",
+				"marathon-lb.example.com A manual-10.0.0.9 10.0.0.9",
+				"marathon-lb.example.com A weighted-10.0.0.1 10.0.0.1",
+				`marathon-lb.example.com TXT  "verification"`,
+			},
+			wantBatches: 1,
+		},
+		{
+			name:     "submits nothing when the records are up to date",
+			existing: []*route53.ResourceRecordSet{weightedRecordSet("10.0.0.1"), enumeratedRecordSet(1, "10.0.0.1")},
+			ips:      []string{"10.0.0.1"},
+			wantRecords: []string{
+				"marathon-lb-1.example.com A  10.0.0.1",
+				"marathon-lb.example.com A weighted-10.0.0.1 10.0.0.1",
+			},
+			wantBatches: 0,
+		},
+		{
+			name:        "fails when the change batch is rejected",
+			existing:    []*route53.ResourceRecordSet{weightedRecordSet("10.0.0.1")},
+			ips:         []string{"10.0.0.2"},
+			changeErr:   awserr.New(route53.ErrCodePriorRequestNotComplete, "the previous change is pending", nil),
+			wantRecords: []string{"marathon-lb.example.com A weighted-10.0.0.1 10.0.0.1"},
+			wantBatches: 1,
+			wantErr:     route53.ErrCodePriorRequestNotComplete,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := parseTestConfig(t)
+			client := newMockRoute53(test.existing...)
+			client.changeErr = test.changeErr
+			marathonClient := newMockMarathonClient(runningApp(test.ips...))
+
+			appErr := updateRecords(context.Background(), cfg, marathonClient, newMockRoute53Provider(client), testTarget(cfg))
+			switch {
+			case test.wantErr == "" && appErr != nil:
+				t.Fatalf("Update failed: %v", appErr.Err)
+			case test.wantErr != "" && appErr == nil:
+				t.Fatalf("Expected error %s", test.wantErr)
+			case test.wantErr != "":
+				var aerr awserr.Error
+				if !errors.As(appErr.Err, &aerr) || aerr.Code() != test.wantErr || appErr.IsFatal {
+					t.Errorf("Expected non-fatal error %s, got %v (fatal: %v)", test.wantErr, appErr.Err, appErr.IsFatal)
+				}
+			}
+
+			if len(client.changeBatches) != test.wantBatches {
+				t.Errorf("Expected %d change batches, got %d", test.wantBatches, len(client.changeBatches))
+			}
+			if records := client.records(); !equalStrings(records, test.wantRecords) {
+				t.Errorf("Expected records\n%s\ngot\n%s", strings.Join(test.wantRecords, "\n"), strings.Join(records, "\n"))
+			}
+		})
+	}
+}
+
+func TestUpdateRecordsKeepsForeignSetIdentifiers(t *testing.T) {
+	// Record sets of the same name and type pointing at the task IPs, but created by others
+	foreign := func(identifier string, ip string) *route53.ResourceRecordSet {
+		recordSet := weightedRecordSet(ip)
+		recordSet.SetIdentifier = aws.String(identifier)
+		return recordSet
+	}
+	client := newMockRoute53(weightedRecordSet("10.0.0.1"), weightedRecordSet("10.0.0.2"),
+		foreign("failover-10.0.0.1", "10.0.0.1"), foreign("failover-10.0.0.2", "10.0.0.2"),
+		enumeratedRecordSet(1, "10.0.0.1"))
+	cfg := parseTestConfig(t)
+
+	appErr := updateRecords(context.Background(), cfg, newMockMarathonClient(runningApp("10.0.0.1")), newMockRoute53Provider(client), testTarget(cfg))
+	if appErr != nil {
+		t.Fatalf("Update failed: %v", appErr.Err)
+	}
+
+	if len(client.changeBatches) != 1 {
+		t.Fatalf("Expected a single change batch, got %d", len(client.changeBatches))
+	}
+	var deleted []string
+	for _, change := range client.changeBatches[0].Changes {
+		if aws.StringValue(change.Action) == route53.ChangeActionDelete {
+			deleted = append(deleted, aws.StringValue(change.ResourceRecordSet.SetIdentifier))
+		}
+	}
+	if !equalStrings(deleted, []string{"weighted-10.0.0.2"}) {
+		t.Errorf("Expected only weighted-10.0.0.2 to be deleted, got %v", deleted)
+	}
+	wantRecords := []string{
+		"marathon-lb-1.example.com A  10.0.0.1",
+		"marathon-lb.example.com A failover-10.0.0.1 10.0.0.1",
+		"marathon-lb.example.com A failover-10.0.0.2 10.0.0.2",
+		"marathon-lb.example.com A weighted-10.0.0.1 10.0.0.1",
+	}
+	if records := client.records(); !equalStrings(records, wantRecords) {
+		t.Errorf("Expected records\n%s\ngot\n%s", strings.Join(wantRecords, "\n"), strings.Join(records, "\n"))
+	}
+}
+
+func TestUpdateRecordsBackfillsDeletedRecords(t *testing.T) {
+	cfg := parseTestConfig(t)
+	client := newMockRoute53()
+	provider := newMockRoute53Provider(client)
+
+	if appErr := updateRecords(context.Background(), cfg, newMockMarathonClient(runningApp("10.0.0.1", "10.0.0.2")), provider, testTarget(cfg)); appErr != nil {
+		t.Fatalf("Initial update failed: %v", appErr.Err)
+	}
+
+	// The weighted record set of a running task is deleted by hand
+	client.mu.Lock()
+	idx := client.indexOf(weightedRecordSet("10.0.0.1"))
+	if idx < 0 {
+		client.mu.Unlock()
+		t.Fatal("Expected the weighted record set of 10.0.0.1 to be created")
+	}
+	client.recordSets = append(client.recordSets[:idx], client.recordSets[idx+1:]...)
+	client.mu.Unlock()
+
+	// A new task is started as well, its record sets are created but not backfilled
+	backfilled := promtestutil.ToFloat64(appMetrics.recordsBackfilled)
+	if appErr := updateRecords(context.Background(), cfg, newMockMarathonClient(runningApp("10.0.0.1", "10.0.0.2", "10.0.0.3")), provider, testTarget(cfg)); appErr != nil {
+		t.Fatalf("Update failed: %v", appErr.Err)
+	}
+
+	if got := promtestutil.ToFloat64(appMetrics.recordsBackfilled) - backfilled; got != 1 {
+		t.Errorf("Expected dns_records_backfilled_total to increase by 1, got %v", got)
+	}
+	wantRecords := []string{
+		"marathon-lb-1.example.com A  10.0.0.1",
+		"marathon-lb-2.example.com A  10.0.0.2",
+		"marathon-lb-3.example.com A  10.0.0.3",
+		"marathon-lb.example.com A weighted-10.0.0.1 10.0.0.1",
+		"marathon-lb.example.com A weighted-10.0.0.2 10.0.0.2",
+		"marathon-lb.example.com A weighted-10.0.0.3 10.0.0.3",
+	}
+	if records := client.records(); !equalStrings(records, wantRecords) {
+		t.Errorf("Expected records\n%s\ngot\n%s", strings.Join(wantRecords, "\n"), strings.Join(records, "\n"))
+	}
+}
+
+func TestUpdateRecordsSkipsNoop(t *testing.T) {
+	cfg := parseTestConfig(t)
+	client := newMockRoute53(weightedRecordSet("10.0.0.1"), weightedRecordSet("10.0.0.2"),
+		enumeratedRecordSet(1, "10.0.0.1"), enumeratedRecordSet(2, "10.0.0.2"))
+	// Any change batch would fail the update
+	client.changeErr = errors.New("Unexpected change batch")
+
+	skipped := promtestutil.ToFloat64(appMetrics.updatesSkippedNoop)
+	appErr := updateRecords(context.Background(), cfg, newMockMarathonClient(runningApp("10.0.0.1", "10.0.0.2")), newMockRoute53Provider(client), testTarget(cfg))
+	if appErr != nil {
+		t.Fatalf("Update failed: %v", appErr.Err)
+	}
+
+	if len(client.changeBatches) != 0 {
+		t.Errorf("Expected no change batch, got %d", len(client.changeBatches))
+	}
+	if got := promtestutil.ToFloat64(appMetrics.updatesSkippedNoop) - skipped; got != 1 {
+		t.Errorf("Expected dns_updates_skipped_noop_total to increase by 1, got %v", got)
+	}
+}
+
+func TestAppErrorIsError(t *testing.T) {
+	var err error = &appError{Err: errors.New("No running tasks found for appId: /marathon-lb"), IsFatal: true}
+
+	if err.Error() != "No running tasks found for appId: /marathon-lb" {
+		t.Errorf("Expected the message of the wrapped error, got %q", err.Error())
+	}
+	var appErr *appError
+	if !errors.As(err, &appErr) || !appErr.IsFatal {
+		t.Errorf("Expected errors.As to find the fatal appError, got %v", appErr)
+	}
+}
+
+func TestUpdateRecordsDeletesStaleHealthChecksWithoutChanges(t *testing.T) {
+	cfg := parseTestConfig(t, "-create-health-checks")
+	weighted := weightedRecordSet("10.0.0.1")
+	weighted.HealthCheckId = aws.String("HC1")
+	client := newMockRoute53(weighted, enumeratedRecordSet(1, "10.0.0.1"))
+	client.addHealthCheck("HC1", "10.0.0.1", 80)
+	// Created before health-check-port was changed to 80
+	client.addHealthCheck("HC0", "10.0.0.1", 8080)
+
+	appErr := updateRecords(context.Background(), cfg, newMockMarathonClient(runningApp("10.0.0.1")), newMockRoute53Provider(client), testTarget(cfg))
+	if appErr != nil {
+		t.Fatalf("Update failed: %v", appErr.Err)
+	}
+
+	if len(client.changeBatches) != 0 {
+		t.Errorf("Expected the records to be up to date, got %d change batches", len(client.changeBatches))
+	}
+	if !equalStrings(client.deletedHealthChecks, []string{"HC0"}) {
+		t.Errorf("Expected the stale health check HC0 to be deleted, got %v", client.deletedHealthChecks)
+	}
+}
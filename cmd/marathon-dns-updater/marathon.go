@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+	marathonapi "github.com/DigDug101/marathon-dns-updater/internal/marathon"
+	marathon "github.com/gambol99/go-marathon"
+)
+
+// MarathonClient is the part of the go-marathon client the updater uses. Everything that talks to
+// Marathon takes it rather than the full client so that it can be replaced, e.g. by a fake.
+type MarathonClient interface {
+	Application(name string) (*marathon.Application, error)
+	Applications(v url.Values) (*marathon.Applications, error)
+	Group(name string) (*marathon.Group, error)
+	Ping() (bool, error)
+}
+
+// newMarathonClient creates the go-marathon client for cfg.MarathonHosts, sending its requests with
+// httpClient, whose transport authenticates them. The client moves on to the next host when a host
+// is unreachable.
+func newMarathonClient(cfg config.Config, httpClient *http.Client) (MarathonClient, error) {
+	config := marathon.NewDefaultConfig()
+	config.URL = strings.Join(cfg.MarathonHosts, ",")
+	config.HTTPClient = httpClient
+	config.HTTPSSEClient = httpClient
+	config.EventsTransport = marathon.EventsTransportSSE
+
+	return marathon.NewClient(config)
+}
+
+// waitForMarathon pings Marathon until it responds, backing off exponentially between attempts. It
+// returns an error once Marathon hasn't responded for maxWait, or if ctx is cancelled first.
+func waitForMarathon(ctx context.Context, client MarathonClient, maxWait time.Duration) error {
+	policy := marathonapi.ReconnectPolicy{Delay: time.Second, MaxDelay: 30 * time.Second}
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		_, err := client.Ping()
+		elapsed := time.Since(start).Round(time.Second)
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("Marathon responded after %v", elapsed)
+			}
+			return nil
+		}
+		if elapsed >= maxWait {
+			return fmt.Errorf("Marathon didn't respond within startup-retry-max-wait %v: %v", maxWait, err)
+		}
+
+		delay := policy.Backoff(attempt)
+		if remaining := maxWait - elapsed; delay > remaining {
+			delay = remaining
+		}
+		log.Printf("WARNING: Marathon didn't respond after %v, retry %d in %v: %v", elapsed, attempt, delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// logEvent logs an event read from the event stream with its fields as key value pairs, so that log
+// pipelines can index the events by app and task. decoded is the event as returned by DecodeEvent,
+// events of the types the updater doesn't act on are logged with their raw JSON at debug level.
+func logEvent(event *marathonapi.Event, decoded *marathon.Event) {
+	if decoded == nil {
+		appLog.log("debug", "Received Marathon event", "eventType", event.Type, "data", string(event.Data))
+		return
+	}
+
+	switch event.Type {
+	case marathonapi.StatusUpdateEvent:
+		var update marathonapi.StatusUpdate
+		if err := json.Unmarshal(event.Data, &update); err != nil {
+			appLog.log("warn", "Unable to decode Marathon event", "eventType", event.Type, "error", err)
+			return
+		}
+		var ips []string
+		for _, address := range update.IPAddresses {
+			ips = append(ips, address.IPAddress)
+		}
+		appLog.log("info", "Received Marathon event", "eventType", event.Type, "taskId", update.TaskID,
+			"appId", update.AppID, "taskStatus", update.TaskStatus, "host", update.Host, "ipAddresses", ips)
+	default:
+		appLog.log("info", "Received Marathon event", "eventType", event.Type, "appIds", marathonapi.EventAppIds(decoded))
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	marathon "github.com/gambol99/go-marathon"
+)
+
+// MockMarathonClient is a MarathonClient serving apps and groups from memory. With Err set every
+// request fails with it.
+type MockMarathonClient struct {
+	mu     sync.Mutex
+	Apps   map[string]*marathon.Application
+	Groups map[string]*marathon.Group
+	Err    error
+	// Requests holds the ids of the apps and groups requested so far
+	Requests []string
+}
+
+func newMockMarathonClient(apps ...*marathon.Application) *MockMarathonClient {
+	client := &MockMarathonClient{Apps: map[string]*marathon.Application{}, Groups: map[string]*marathon.Group{}}
+	for _, app := range apps {
+		client.Apps[app.ID] = app
+	}
+	return client
+}
+
+func (c *MockMarathonClient) Application(name string) (*marathon.Application, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Requests = append(c.Requests, name)
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	app, ok := c.Apps[name]
+	if !ok {
+		return nil, fmt.Errorf("App '%s' does not exist", name)
+	}
+	return app, nil
+}
+
+func (c *MockMarathonClient) Applications(v url.Values) (*marathon.Applications, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	apps := &marathon.Applications{}
+	for _, app := range c.Apps {
+		apps.Apps = append(apps.Apps, *app)
+	}
+	return apps, nil
+}
+
+func (c *MockMarathonClient) Group(name string) (*marathon.Group, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Requests = append(c.Requests, name)
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	group, ok := c.Groups[name]
+	if !ok {
+		return nil, fmt.Errorf("Group '%s' does not exist", name)
+	}
+	return group, nil
+}
+
+func (c *MockMarathonClient) Ping() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Err != nil {
+		return false, c.Err
+	}
+	return true, nil
+}
+
+func TestUpdateRecordsWithMockMarathonClient(t *testing.T) {
+	cfg := parseTestConfig(t)
+	client := newMockMarathonClient(runningApp("10.0.0.2", "10.0.0.1"))
+	provider := newFakeProvider()
+
+	if appErr := updateRecords(context.Background(), cfg, client, provider, testTarget(cfg)); appErr != nil {
+		t.Fatalf("Update failed: %v", appErr.Err)
+	}
+
+	if values := provider.values("marathon-lb.example.com"); !equalStrings(values, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("Expected weighted records of both tasks, got %v", values)
+	}
+	for name, value := range map[string]string{"marathon-lb-1.example.com": "10.0.0.1", "marathon-lb-2.example.com": "10.0.0.2"} {
+		if values := provider.values(name); !equalStrings(values, []string{value}) {
+			t.Errorf("Expected %s to point at %s, got %v", name, value, values)
+		}
+	}
+	if !equalStrings(client.Requests, []string{"/marathon-lb"}) {
+		t.Errorf("Expected a single request for /marathon-lb, got %v", client.Requests)
+	}
+}
+
+func TestUpdateRecordsMarathonErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		cachedState bool
+		wantFatal   bool
+	}{
+		{name: "without cached state", cachedState: false, wantFatal: true},
+		{name: "with cached state", cachedState: true, wantFatal: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			previous := lastKnownState
+			defer func() { lastKnownState = previous }()
+			lastKnownState = nil
+			if test.cachedState {
+				state, err := loadStateFile(filepath.Join(t.TempDir(), "state.json"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := state.set("/marathon-lb", appState{IPs: []string{"10.0.0.1"}}); err != nil {
+					t.Fatal(err)
+				}
+				lastKnownState = state
+			}
+
+			cfg := parseTestConfig(t)
+			client := newMockMarathonClient()
+			client.Err = errors.New("Marathon is down")
+			provider := newFakeProvider()
+
+			appErr := updateRecords(context.Background(), cfg, client, provider, testTarget(cfg))
+			if appErr == nil {
+				t.Fatal("Expected an error while Marathon is down")
+			}
+			if appErr.IsFatal != test.wantFatal {
+				t.Errorf("Expected fatal %v, got %v: %v", test.wantFatal, appErr.IsFatal, appErr.Err)
+			}
+			if !strings.Contains(appErr.Err.Error(), "Unable to fetch appId: /marathon-lb") || !strings.Contains(appErr.Err.Error(), "Marathon is down") {
+				t.Errorf("Expected the error to name the app and the reason, got %v", appErr.Err)
+			}
+			if len(provider.records) != 0 {
+				t.Errorf("Expected no records to be changed, got %v", provider.records)
+			}
+		})
+	}
+}
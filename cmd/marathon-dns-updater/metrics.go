@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the prometheus collectors exposed on /metrics. They are registered on their own
+// registry rather than the global default one so tests can create fresh instances.
+type metrics struct {
+	registry               *prometheus.Registry
+	updateTotal            *prometheus.CounterVec
+	updateDuration         prometheus.Histogram
+	updateRetries          prometheus.Counter
+	marathonFetchErrors    prometheus.Counter
+	route53APIErrors       *prometheus.CounterVec
+	tasksExcludedUnhealthy *prometheus.GaugeVec
+	updatesSkippedNoop     prometheus.Counter
+	updateTimeouts         prometheus.Counter
+	recordsBackfilled      prometheus.Counter
+	recordsActive          *prometheus.GaugeVec
+	recordsAdded           *prometheus.GaugeVec
+	recordsRemoved         *prometheus.GaugeVec
+}
+
+var appMetrics = newMetrics()
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		updateTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dns_update_total",
+			Help: "Number of DNS record updates by result",
+		}, []string{"result"}),
+		updateDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "dns_update_duration_seconds",
+			Help:    "Duration of DNS record updates",
+			Buckets: prometheus.ExponentialBuckets(0.5, 2, 10),
+		}),
+		updateRetries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dns_update_retries_total",
+			Help: "Number of retries of failed DNS record updates",
+		}),
+		marathonFetchErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "marathon_fetch_errors_total",
+			Help: "Number of failed requests for marathon app state",
+		}),
+		route53APIErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "route53_api_errors_total",
+			Help: "Number of failed Route53 API calls by error code",
+		}, []string{"code"}),
+		tasksExcludedUnhealthy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dns_tasks_excluded_unhealthy",
+			Help: "Number of tasks left out of DNS by the latest update because of failing health checks",
+		}, []string{"app_id"}),
+		updatesSkippedNoop: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dns_updates_skipped_noop_total",
+			Help: "Number of DNS updates skipped because the records were already up to date",
+		}),
+		updateTimeouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dns_update_timeouts_total",
+			Help: "Number of DNS record updates given up on after update-timeout",
+		}),
+		recordsBackfilled: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dns_records_backfilled_total",
+			Help: "Number of records of published IPs re-created because they were missing",
+		}),
+		recordsActive: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dns_records_active",
+			Help: "Number of IPs the records point at after the latest successful update",
+		}, []string{"app_id", "record_set"}),
+		recordsAdded: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dns_records_added_last_cycle",
+			Help: "Number of IPs added to the records by the latest successful update",
+		}, []string{"app_id", "record_set"}),
+		recordsRemoved: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dns_records_removed_last_cycle",
+			Help: "Number of IPs removed from the records by the latest successful update",
+		}, []string{"app_id", "record_set"}),
+	}
+
+	m.registry.MustRegister(
+		m.updateTotal,
+		m.updateDuration,
+		m.updateRetries,
+		m.marathonFetchErrors,
+		m.route53APIErrors,
+		m.tasksExcludedUnhealthy,
+		m.updatesSkippedNoop,
+		m.updateTimeouts,
+		m.recordsBackfilled,
+		m.recordsActive,
+		m.recordsAdded,
+		m.recordsRemoved,
+	)
+
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+)
+
+// notification is the JSON payload POSTed to notify-url after a successful update
+type notification struct {
+	Timestamp    time.Time `json:"timestamp"`
+	AppID        string    `json:"app_id"`
+	RecordSet    string    `json:"record_set"`
+	HostedZoneID string    `json:"hosted_zone_id"`
+	AddedIPs     []string  `json:"added_ips"`
+	RemovedIPs   []string  `json:"removed_ips"`
+}
+
+// pendingNotifications tracks the notifications still being sent, so a single update with -once can
+// wait for them before exiting
+var pendingNotifications sync.WaitGroup
+
+// notifyUpdate sends the IPs added to and removed from the records of target by an update in the
+// background. It has to be called before the update is recorded, as the IPs of the previous update
+// are the baseline. Failures are only logged.
+func notifyUpdate(cfg config.Config, target config.AppRecordSet, taskIps map[string]string, taskIpv6s map[string]string) {
+	if cfg.NotifyURL == "" {
+		return
+	}
+
+	_, added, removed := publishedChanges(target, taskIps, taskIpv6s)
+	payload := notification{
+		Timestamp:    time.Now().UTC(),
+		AppID:        target.AppID,
+		RecordSet:    target.RecordSet,
+		HostedZoneID: cfg.HostedZoneID,
+		AddedIPs:     added,
+		RemovedIPs:   removed,
+	}
+
+	pendingNotifications.Add(1)
+	go func() {
+		defer pendingNotifications.Done()
+		if err := sendNotification(cfg, payload); err != nil {
+			log.Printf("WARNING: Unable to notify %s of the update of %s: %v", cfg.NotifyURL, target.RecordSet, err)
+		}
+	}()
+}
+
+// sendNotification POSTs payload to notify-url, retrying notify-retries times with a growing delay
+func sendNotification(cfg config.Config, payload notification) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: cfg.NotifyTimeout}
+	for attempt := 0; ; attempt++ {
+		err = postNotification(client, cfg.NotifyURL, body)
+		if err == nil || attempt >= cfg.NotifyRetries {
+			return err
+		}
+		log.Printf("Retrying notification of %s after error: %v", cfg.NotifyURL, err)
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+	}
+}
+
+func postNotification(client *http.Client, url string, body []byte) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// missingIps returns the ips that are not in other, an empty list rather than nil if there are none
+func missingIps(ips []string, other []string) []string {
+	isOther := map[string]bool{}
+	for _, ip := range other {
+		isOther[ip] = true
+	}
+
+	missing := []string{}
+	for _, ip := range ips {
+		if !isOther[ip] {
+			missing = append(missing, ip)
+		}
+	}
+	return missing
+}
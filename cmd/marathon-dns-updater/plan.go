@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// changePlan is the change batch about to be submitted for an app as written to plan-output
+type changePlan struct {
+	Timestamp    time.Time         `json:"timestamp"`
+	AppID        string            `json:"appId"`
+	HostedZoneID string            `json:"hostedZoneId"`
+	Changes      []*route53.Change `json:"changes"`
+}
+
+// planOutputMu serializes the plans of apps updated concurrently
+var planOutputMu sync.Mutex
+
+// writePlan appends the plan as a single line of JSON to path, or writes it to stdout if path is -
+func writePlan(path string, plan changePlan) error {
+	line, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	planOutputMu.Lock()
+	defer planOutputMu.Unlock()
+
+	if path == "-" {
+		_, err = os.Stdout.Write(line)
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// registerPprof adds the net/http/pprof handlers under /debug/pprof/ to mux
+func registerPprof(mux *http.ServeMux) {
+	mux.Handle("/debug/pprof/", withoutWriteDeadline(http.HandlerFunc(pprof.Index)))
+	mux.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	mux.Handle("/debug/pprof/profile", withoutWriteDeadline(http.HandlerFunc(pprof.Profile)))
+	mux.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	mux.Handle("/debug/pprof/trace", withoutWriteDeadline(http.HandlerFunc(pprof.Trace)))
+}
+
+// withoutWriteDeadline lifts the write timeout of the admin server for handler, as profiles and traces
+// are collected for seconds before the response is written
+func withoutWriteDeadline(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		handler.ServeHTTP(w, r)
+	})
+}
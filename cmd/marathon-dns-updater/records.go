@@ -0,0 +1,677 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+	"github.com/DigDug101/marathon-dns-updater/internal/dns"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	marathon "github.com/gambol99/go-marathon"
+)
+
+const (
+	SRV_PRIORITY = 10
+	SRV_WEIGHT   = 10
+
+	DEFAULT_WEIGHT = 10
+	MAX_WEIGHT     = 255
+	WEIGHT_LABEL   = "DNS_WEIGHT"
+
+	// NORMALIZED_WEIGHT_SUM is what the weights of a record set add up to with normalize-weights
+	NORMALIZED_WEIGHT_SUM = 1000
+
+	ALIAS_SET_IDENTIFIER = "weighted-alias"
+
+	MESOS_DNS_DOMAIN = "marathon.mesos"
+)
+
+// We sort by IP to prevent unnecessary re-ordering of records
+func sortedIps(ips map[string]string) []string {
+	sorted := []string{}
+	for _, ip := range ips {
+		sorted = append(sorted, ip)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// excludeIps drops the ips within any of the excluded networks. Values that aren't IPs, like the
+// hosts of record-value=host, are kept.
+func excludeIps(ips map[string]string, excluded []*net.IPNet) map[string]string {
+	if len(excluded) == 0 {
+		return ips
+	}
+
+	included := map[string]string{}
+	for key, value := range ips {
+		ip := net.ParseIP(value)
+		isExcluded := false
+		for _, network := range excluded {
+			if ip != nil && network.Contains(ip) {
+				isExcluded = true
+				break
+			}
+		}
+		if isExcluded {
+			log.Printf("DEBUG: Excluding IP %s within exclude-ips", value)
+			continue
+		}
+		included[key] = value
+	}
+	return included
+}
+
+// limitIps caps the ips at max. With preferExisting the ips that are in existing, i.e. that the
+// records already point at, are kept ahead of new ones so that a new task doesn't displace a task
+// that is still running.
+func limitIps(ips map[string]string, max int, existing []string, preferExisting bool) map[string]string {
+	if len(ips) <= max {
+		return ips
+	}
+
+	candidates := sortedIps(ips)
+	if preferExisting {
+		isExisting := map[string]bool{}
+		for _, ip := range existing {
+			isExisting[ip] = true
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return isExisting[candidates[i]] && !isExisting[candidates[j]]
+		})
+	}
+
+	log.Printf("Dropping IPs beyond max-ips %d: %v", max, candidates[max:])
+	limited := map[string]string{}
+	for _, ip := range candidates[:max] {
+		limited[ip] = ip
+	}
+	return limited
+}
+
+// mesosDNSName returns the Mesos DNS name of task, e.g. marathon-lb-1.marathon-lb.marathon.mesos for
+// task marathon-lb.1 of app /marathon-lb. Like in Mesos DNS the app name is the path of the app id
+// in reverse, so /infra/lb is lb-infra.
+func mesosDNSName(task *marathon.Task) string {
+	segments := strings.Split(strings.Trim(task.AppID, "/"), "/")
+	for i, j := 0, len(segments)-1; i < j; i, j = i+1, j-1 {
+		segments[i], segments[j] = segments[j], segments[i]
+	}
+	return fmt.Sprintf("%s.%s.%s", dnsLabel(task.ID), dnsLabel(strings.Join(segments, "-")), MESOS_DNS_DOMAIN)
+}
+
+// dnsLabel turns value into a valid DNS label, replacing invalid characters with -
+func dnsLabel(value string) string {
+	label := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(value))
+	if len(label) > config.MAX_LABEL_LENGTH {
+		label = label[:config.MAX_LABEL_LENGTH]
+	}
+	return strings.Trim(label, "-")
+}
+
+// resolveAll returns an error if any of the names doesn't resolve
+func resolveAll(ctx context.Context, names map[string]string) error {
+	for _, name := range names {
+		if _, err := net.DefaultResolver.LookupHost(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordChanges builds the upserts for the weighted and/or enumerated record sets named after
+// recordSet of the given record type (A or AAAA) pointing at the sorted list of ips. Weighted
+// records get the given weight.
+func recordChanges(cfg config.Config, recordSet string, ips []string, recordType string, weight int64, weighted bool, enumerated bool) ([]*route53.Change, *appError) {
+	var changes []*route53.Change
+
+	// Weighted records alias the ELB in front of the tasks instead of pointing at each task
+	aliased := weighted && cfg.AliasTarget != ""
+	if aliased && len(ips) > 0 {
+		recordSet := &route53.ResourceRecordSet{
+			Name:          aws.String(recordSet),
+			Type:          aws.String(recordType),
+			Weight:        aws.Int64(weight),
+			SetIdentifier: aws.String(ALIAS_SET_IDENTIFIER),
+			AliasTarget: &route53.AliasTarget{
+				DNSName:              aws.String(cfg.AliasTarget),
+				HostedZoneId:         aws.String(cfg.AliasHostedZone),
+				EvaluateTargetHealth: aws.Bool(true),
+			},
+		}
+		log.Printf("DEBUG: Creating record set %s", recordSet)
+		changes = append(changes, &route53.Change{
+			Action:            aws.String(route53.ChangeActionUpsert),
+			ResourceRecordSet: recordSet,
+		})
+	}
+
+	for idx, ip := range ips {
+		if weighted && !aliased {
+			record := &route53.ResourceRecord{
+				Value: aws.String(ip),
+			}
+			recordIdentifier := "weighted-" + ip
+			recordSet := &route53.ResourceRecordSet{
+				Name:            aws.String(recordSet),
+				Type:            aws.String(recordType),
+				TTL:             aws.Int64(cfg.WeightedTTL),
+				Weight:          aws.Int64(weight),
+				SetIdentifier:   &recordIdentifier,
+				ResourceRecords: []*route53.ResourceRecord{record},
+			}
+			recordUpsert := &route53.Change{
+				Action:            aws.String(route53.ChangeActionUpsert),
+				ResourceRecordSet: recordSet,
+			}
+			log.Printf("DEBUG: Creating record set %s", recordSet)
+			changes = append(changes, recordUpsert)
+		}
+
+		if enumerated {
+			record := &route53.ResourceRecord{
+				Value: aws.String(ip),
+			}
+			enumeratedType := recordType
+			// Enumerated records point at the ELB in front of the tasks instead of the tasks themselves
+			if cfg.CNAMETarget != "" {
+				record.Value = aws.String(cfg.CNAMETarget)
+				enumeratedType = route53.RRTypeCname
+			}
+			// AAAA records are numbered apart from the A records, unless there are no A records at all
+			enumeratedSet := recordSet
+			if recordType == route53.RRTypeAaaa && cfg.RecordType != config.RECORD_TYPE_AAAA_ONLY {
+				enumeratedSet = ipv6EnumeratedRecordSet(recordSet, cfg.AAAAEnumeratedPrefix)
+			}
+			recordSetName, appErr := enumeratedName(enumeratedSet, cfg.EnumeratedStartIndex+idx)
+			if appErr != nil {
+				return nil, appErr
+			}
+			recordSet := &route53.ResourceRecordSet{
+				Name:            &recordSetName,
+				Type:            aws.String(enumeratedType),
+				TTL:             aws.Int64(cfg.EnumeratedTTL),
+				ResourceRecords: []*route53.ResourceRecord{record},
+			}
+			recordUpsert := &route53.Change{
+				Action:            aws.String(route53.ChangeActionUpsert),
+				ResourceRecordSet: recordSet,
+			}
+			log.Printf("DEBUG: Creating record set %s", recordSet)
+			changes = append(changes, recordUpsert)
+		}
+	}
+
+	if weighted && !aliased && cfg.NormalizeWeights {
+		normalizeWeights(recordSet, changes)
+	}
+
+	return changes, nil
+}
+
+// normalizeWeights scales the weights of the weighted record sets among changes so that they add up
+// to NORMALIZED_WEIGHT_SUM, distributing what is lost to rounding down with the largest remainder
+// method. The weights are left as they are if they are all 0, or if a normalized weight would
+// exceed the maximum Route53 accepts, i.e. for record sets with only a few records.
+func normalizeWeights(recordSet string, changes []*route53.Change) {
+	var weighted []*route53.ResourceRecordSet
+	var total int64
+	for _, change := range changes {
+		set := change.ResourceRecordSet
+		if strings.HasPrefix(aws.StringValue(set.SetIdentifier), "weighted-") {
+			weighted = append(weighted, set)
+			total += aws.Int64Value(set.Weight)
+		}
+	}
+	if total == 0 {
+		return
+	}
+
+	weights := make([]int64, len(weighted))
+	remainders := make([]int64, len(weighted))
+	var assigned int64
+	for idx, set := range weighted {
+		scaled := aws.Int64Value(set.Weight) * NORMALIZED_WEIGHT_SUM
+		weights[idx], remainders[idx] = scaled/total, scaled%total
+		assigned += weights[idx]
+	}
+	// The records with the largest remainders get the units lost to rounding, ties go to the first
+	order := make([]int, len(weighted))
+	for idx := range order {
+		order[idx] = idx
+	}
+	sort.SliceStable(order, func(i, j int) bool { return remainders[order[i]] > remainders[order[j]] })
+	for _, idx := range order[:NORMALIZED_WEIGHT_SUM-assigned] {
+		weights[idx]++
+	}
+
+	var before, after []string
+	for idx, set := range weighted {
+		if weights[idx] > MAX_WEIGHT {
+			log.Printf("WARNING: Not normalizing the weights of %s, %d records can't add up to %d with weights of at most %d",
+				recordSet, len(weighted), NORMALIZED_WEIGHT_SUM, MAX_WEIGHT)
+			return
+		}
+		before = append(before, fmt.Sprintf("%s=%d", aws.StringValue(set.ResourceRecords[0].Value), aws.Int64Value(set.Weight)))
+		after = append(after, fmt.Sprintf("%s=%d", aws.StringValue(set.ResourceRecords[0].Value), weights[idx]))
+	}
+	for idx, set := range weighted {
+		set.Weight = aws.Int64(weights[idx])
+	}
+	log.Printf("Normalized the weights of %s from %v to %v", recordSet, before, after)
+}
+
+// latencyChanges builds the upserts for the latency record sets named recordSet of the given record
+// type, one per IP of the sorted list of ips, all in the AWS region of the tasks
+func latencyChanges(cfg config.Config, recordSet string, ips []string, recordType string) []*route53.Change {
+	var changes []*route53.Change
+	for _, ip := range ips {
+		latencySet := &route53.ResourceRecordSet{
+			Name:            aws.String(recordSet),
+			Type:            aws.String(recordType),
+			TTL:             aws.Int64(cfg.WeightedTTL),
+			Region:          aws.String(cfg.AWSRegion),
+			SetIdentifier:   aws.String("latency-" + ip),
+			ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(ip)}},
+		}
+		log.Printf("DEBUG: Creating record set %s", latencySet)
+		changes = append(changes, &route53.Change{
+			Action:            aws.String(route53.ChangeActionUpsert),
+			ResourceRecordSet: latencySet,
+		})
+	}
+	return changes
+}
+
+// multivalueChanges builds the upserts for the multivalue answer record sets named recordSet of the
+// given record type, one per IP of the sorted list of ips. Route53 answers with up to 8 of them,
+// skipping those whose health check fails.
+func multivalueChanges(cfg config.Config, recordSet string, ips []string, recordType string) []*route53.Change {
+	var changes []*route53.Change
+	for _, ip := range ips {
+		multivalueSet := &route53.ResourceRecordSet{
+			Name:             aws.String(recordSet),
+			Type:             aws.String(recordType),
+			TTL:              aws.Int64(cfg.WeightedTTL),
+			MultiValueAnswer: aws.Bool(true),
+			SetIdentifier:    aws.String("multivalue-" + ip),
+			ResourceRecords:  []*route53.ResourceRecord{{Value: aws.String(ip)}},
+		}
+		log.Printf("DEBUG: Creating record set %s", multivalueSet)
+		changes = append(changes, &route53.Change{
+			Action:            aws.String(route53.ChangeActionUpsert),
+			ResourceRecordSet: multivalueSet,
+		})
+	}
+	return changes
+}
+
+// geoChanges builds the upserts for the geolocation record sets named recordSet of the given record
+// type pointing at the sorted list of ips: one for the configured continent or country and, with
+// geo-default, one for all other locations. Route53 allows a single record set per location, so
+// unlike weighted records they hold all IPs.
+func geoChanges(cfg config.Config, recordSet string, ips []string, recordType string) []*route53.Change {
+	if len(ips) == 0 {
+		return nil
+	}
+	var records []*route53.ResourceRecord
+	for _, ip := range ips {
+		records = append(records, &route53.ResourceRecord{Value: aws.String(ip)})
+	}
+
+	identifiers := []string{"geo-" + cfg.GeoCountryCode}
+	locations := []*route53.GeoLocation{{CountryCode: aws.String(cfg.GeoCountryCode)}}
+	if cfg.GeoContinentCode != "" {
+		identifiers = []string{"geo-" + cfg.GeoContinentCode}
+		locations = []*route53.GeoLocation{{ContinentCode: aws.String(cfg.GeoContinentCode)}}
+	}
+	if cfg.GeoDefault {
+		// The country code * is Route53's default location
+		identifiers = append(identifiers, "geo-default")
+		locations = append(locations, &route53.GeoLocation{CountryCode: aws.String("*")})
+	}
+
+	var changes []*route53.Change
+	for idx, location := range locations {
+		geoSet := &route53.ResourceRecordSet{
+			Name:            aws.String(recordSet),
+			Type:            aws.String(recordType),
+			TTL:             aws.Int64(cfg.WeightedTTL),
+			SetIdentifier:   aws.String(identifiers[idx]),
+			GeoLocation:     location,
+			ResourceRecords: records,
+		}
+		log.Printf("DEBUG: Creating record set %s", geoSet)
+		changes = append(changes, &route53.Change{
+			Action:            aws.String(route53.ChangeActionUpsert),
+			ResourceRecordSet: geoSet,
+		})
+	}
+	return changes
+}
+
+// failoverChanges builds the upserts for the failover record sets named recordSet: the primary
+// pointing at the sorted list of ips and the secondary pointing at the static failover-secondary-ip,
+// both of recordType. The primary is associated with its health check separately.
+func failoverChanges(cfg config.Config, recordSet string, ips []string, recordType string) []*route53.Change {
+	var changes []*route53.Change
+
+	if cfg.RecordSetTypes[config.FAILOVER_PRIMARY] && len(ips) > 0 {
+		var records []*route53.ResourceRecord
+		for _, ip := range ips {
+			records = append(records, &route53.ResourceRecord{Value: aws.String(ip)})
+		}
+		primarySet := &route53.ResourceRecordSet{
+			Name:            aws.String(recordSet),
+			Type:            aws.String(recordType),
+			TTL:             aws.Int64(cfg.WeightedTTL),
+			SetIdentifier:   aws.String(config.FAILOVER_PRIMARY),
+			Failover:        aws.String(route53.ResourceRecordSetFailoverPrimary),
+			ResourceRecords: records,
+		}
+		log.Printf("DEBUG: Creating record set %s", primarySet)
+		changes = append(changes, &route53.Change{
+			Action:            aws.String(route53.ChangeActionUpsert),
+			ResourceRecordSet: primarySet,
+		})
+	}
+
+	if cfg.RecordSetTypes[config.FAILOVER_SECONDARY] {
+		secondarySet := &route53.ResourceRecordSet{
+			Name:            aws.String(recordSet),
+			Type:            aws.String(recordType),
+			TTL:             aws.Int64(cfg.WeightedTTL),
+			SetIdentifier:   aws.String(config.FAILOVER_SECONDARY),
+			Failover:        aws.String(route53.ResourceRecordSetFailoverSecondary),
+			ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(cfg.FailoverSecondaryIP)}},
+		}
+		log.Printf("DEBUG: Creating record set %s", secondarySet)
+		changes = append(changes, &route53.Change{
+			Action:            aws.String(route53.ChangeActionUpsert),
+			ResourceRecordSet: secondarySet,
+		})
+	}
+
+	return changes
+}
+
+// recordWeight returns the weight of the weighted records of app. A DNS_WEIGHT label on the app takes
+// precedence, otherwise with weighted-by=cpu the weight is the app's CPU allocation per task times
+// 100, rounded to the nearest integer and clamped to the 1-255 range accepted by Route53, so 0.5
+// CPUs gives a weight of 50.
+func recordWeight(cfg config.Config, app *marathon.Application) int64 {
+	if app.Labels != nil {
+		if label, ok := (*app.Labels)[WEIGHT_LABEL]; ok {
+			weight, err := strconv.ParseInt(label, 10, 64)
+			if err == nil && weight >= 0 && weight <= MAX_WEIGHT {
+				log.Printf("DEBUG: Using weight %d from the %s label of appId: %s", weight, WEIGHT_LABEL, app.ID)
+				return weight
+			}
+			log.Printf("WARNING: Ignoring invalid %s label %q of appId: %s, expected 0-%d", WEIGHT_LABEL, label, app.ID, MAX_WEIGHT)
+		}
+	}
+
+	if cfg.WeightedBy != config.WEIGHTED_BY_CPU {
+		log.Printf("DEBUG: Using weight %d for appId: %s", DEFAULT_WEIGHT, app.ID)
+		return DEFAULT_WEIGHT
+	}
+
+	weight := int64(math.Round(app.CPUs * 100))
+	if weight < 1 {
+		weight = 1
+	} else if weight > MAX_WEIGHT {
+		weight = MAX_WEIGHT
+	}
+	log.Printf("DEBUG: Using weight %d for %.2f CPUs of appId: %s", weight, app.CPUs, app.ID)
+	return weight
+}
+
+// recordSetKey identifies a record set by name and type
+func recordSetKey(recordSet *route53.ResourceRecordSet) string {
+	return strings.ToLower(strings.TrimSuffix(*recordSet.Name, ".")) + " " + *recordSet.Type
+}
+
+// sameRecordSet reports whether the record sets a and b are equal, regardless of the order of
+// their values and of trailing dots in names
+func sameRecordSet(a *route53.ResourceRecordSet, b *route53.ResourceRecordSet) bool {
+	if recordSetKey(a) != recordSetKey(b) ||
+		aws.StringValue(a.SetIdentifier) != aws.StringValue(b.SetIdentifier) ||
+		aws.Int64Value(a.Weight) != aws.Int64Value(b.Weight) ||
+		aws.Int64Value(a.TTL) != aws.Int64Value(b.TTL) ||
+		aws.StringValue(a.Failover) != aws.StringValue(b.Failover) ||
+		aws.StringValue(a.Region) != aws.StringValue(b.Region) ||
+		aws.BoolValue(a.MultiValueAnswer) != aws.BoolValue(b.MultiValueAnswer) ||
+		!sameGeoLocation(a.GeoLocation, b.GeoLocation) ||
+		aws.StringValue(a.HealthCheckId) != aws.StringValue(b.HealthCheckId) {
+		return false
+	}
+
+	if (a.AliasTarget == nil) != (b.AliasTarget == nil) {
+		return false
+	}
+	if a.AliasTarget != nil {
+		if !strings.EqualFold(strings.TrimSuffix(aws.StringValue(a.AliasTarget.DNSName), "."), strings.TrimSuffix(aws.StringValue(b.AliasTarget.DNSName), ".")) ||
+			aws.StringValue(a.AliasTarget.HostedZoneId) != aws.StringValue(b.AliasTarget.HostedZoneId) ||
+			aws.BoolValue(a.AliasTarget.EvaluateTargetHealth) != aws.BoolValue(b.AliasTarget.EvaluateTargetHealth) {
+			return false
+		}
+	}
+
+	var aValues, bValues []string
+	for _, record := range a.ResourceRecords {
+		aValues = append(aValues, aws.StringValue(record.Value))
+	}
+	for _, record := range b.ResourceRecords {
+		bValues = append(bValues, aws.StringValue(record.Value))
+	}
+	return dns.SameStrings(aValues, bValues)
+}
+
+// sameGeoLocation reports whether the geolocations a and b, either of which may be nil, are equal
+func sameGeoLocation(a *route53.GeoLocation, b *route53.GeoLocation) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return aws.StringValue(a.ContinentCode) == aws.StringValue(b.ContinentCode) &&
+		aws.StringValue(a.CountryCode) == aws.StringValue(b.CountryCode) &&
+		aws.StringValue(a.SubdivisionCode) == aws.StringValue(b.SubdivisionCode)
+}
+
+// enumeratedName returns the name of the enumerated record of recordSet numbered number, e.g.
+// marathon-lb-1.example.com for marathon-lb.example.com and 1
+func enumeratedName(recordSet string, number int) (string, *appError) {
+	parts := strings.SplitN(recordSet, ".", 2)
+
+	if len(parts) != 2 {
+		return "", &appError{
+			Err:     fmt.Errorf("record-set-name must have at least one . separator for enumerated records"),
+			IsFatal: true,
+		}
+	}
+
+	return fmt.Sprintf("%s-%d.%s", parts[0], number, parts[1]), nil
+}
+
+// isManagedRecordSet reports whether existing, listed along with recordSet, may have been created by
+// the updater and may be deleted. Its type has to be one updateRecords creates and it has to be named
+// recordSet or one of its enumerated names. Beyond that a record set with a set identifier has to
+// match managed-identifier-prefix, while one without, like the simple and enumerated records of the
+// updater, is managed by its name alone.
+func isManagedRecordSet(cfg config.Config, recordSet string, existing *route53.ResourceRecordSet) bool {
+	if !isUpdatedRecordType(cfg, aws.StringValue(existing.Type)) ||
+		!dns.IsManagedRecordName(strings.ToLower(recordSet), strings.ToLower(aws.StringValue(existing.Name)), cfg.AAAAEnumeratedPrefix) {
+		return false
+	}
+	if existing.SetIdentifier == nil {
+		return true
+	}
+	return config.IsManagedSetIdentifier(cfg, *existing.SetIdentifier)
+}
+
+// isUpdatedRecordType reports whether updateRecords may create records of recordType, and so delete
+// them: those of ManagedRecordTypes, SRV records with the srv record set type and TXT records with
+// create-txt-records. Records of other types sharing the names, e.g. TXT records for domain
+// verification or the NS and SOA records of the zone apex, are left alone.
+func isUpdatedRecordType(cfg config.Config, recordType string) bool {
+	return dns.IsManagedRecordType(recordType) ||
+		recordType == route53.RRTypeSrv && cfg.RecordSetTypes[config.SRV] ||
+		recordType == route53.RRTypeTxt && cfg.CreateTXTRecords
+}
+
+// ipv6EnumeratedRecordSet returns the record set the enumerated AAAA records of recordSet are named
+// after with aaaaPrefix, e.g. marathon-lb-ipv6.example.com for marathon-lb.example.com, so that they
+// are numbered separately from the enumerated A records
+func ipv6EnumeratedRecordSet(recordSet string, aaaaPrefix string) string {
+	parts := strings.SplitN(recordSet, ".", 2)
+	if len(parts) != 2 {
+		// enumeratedName reports the missing separator
+		return recordSet
+	}
+	return parts[0] + "-" + aaaaPrefix + "." + parts[1]
+}
+
+// srvTarget is a host of a running task and the ports it exposes
+type srvTarget struct {
+	Host  string
+	Ports []int
+}
+
+// srvChanges builds the upsert for the SRV record set named recordSet pointing at every port of
+// every target, as well as an enumerated SRV record set per target pointing at its ports
+func srvChanges(cfg config.Config, recordSet string, targets []srvTarget) ([]*route53.Change, *appError) {
+	var changes []*route53.Change
+	var allRecords []*route53.ResourceRecord
+
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Host < targets[j].Host })
+
+	for idx, target := range targets {
+		var records []*route53.ResourceRecord
+		for _, port := range target.Ports {
+			records = append(records, &route53.ResourceRecord{
+				Value: aws.String(fmt.Sprintf("%d %d %d %s", SRV_PRIORITY, SRV_WEIGHT, port, target.Host)),
+			})
+		}
+		if len(records) == 0 {
+			continue
+		}
+		allRecords = append(allRecords, records...)
+
+		recordSetName, appErr := enumeratedName(recordSet, cfg.EnumeratedStartIndex+idx)
+		if appErr != nil {
+			return nil, appErr
+		}
+		enumeratedSet := &route53.ResourceRecordSet{
+			Name:            &recordSetName,
+			Type:            aws.String(route53.RRTypeSrv),
+			TTL:             aws.Int64(cfg.EnumeratedTTL),
+			ResourceRecords: records,
+		}
+		log.Printf("DEBUG: Creating record set %s", enumeratedSet)
+		changes = append(changes, &route53.Change{
+			Action:            aws.String(route53.ChangeActionUpsert),
+			ResourceRecordSet: enumeratedSet,
+		})
+	}
+
+	if len(allRecords) > 0 {
+		srvSet := &route53.ResourceRecordSet{
+			Name:            aws.String(recordSet),
+			Type:            aws.String(route53.RRTypeSrv),
+			TTL:             aws.Int64(cfg.WeightedTTL),
+			ResourceRecords: allRecords,
+		}
+		log.Printf("DEBUG: Creating record set %s", srvSet)
+		changes = append(changes, &route53.Change{
+			Action:            aws.String(route53.ChangeActionUpsert),
+			ResourceRecordSet: srvSet,
+		})
+	}
+
+	return changes, nil
+}
+
+// taskMetadata is the content of the TXT records describing the task behind an IP
+type taskMetadata struct {
+	TaskID   string `json:"taskId"`
+	Version  string `json:"version"`
+	StagedAt string `json:"stagedAt"`
+}
+
+// txtChanges builds a TXT record set for every name of the A and AAAA upserts, with the metadata of
+// the task behind each of their IPs. TXT record sets of names that are no longer upserted are
+// deleted along with their A records.
+func txtChanges(upserts []*route53.Change, metadata map[string]taskMetadata) []*route53.Change {
+	var changes []*route53.Change
+	txtSets := map[string]*route53.ResourceRecordSet{}
+
+	for _, upsert := range upserts {
+		recordSet := upsert.ResourceRecordSet
+		if *recordSet.Type != route53.RRTypeA && *recordSet.Type != route53.RRTypeAaaa {
+			continue
+		}
+		for _, record := range recordSet.ResourceRecords {
+			task, ok := metadata[*record.Value]
+			if !ok {
+				continue
+			}
+			value, err := json.Marshal(task)
+			if err != nil {
+				log.Printf("WARNING: Unable to encode metadata of task %s: %v", task.TaskID, err)
+				continue
+			}
+
+			txtSet, ok := txtSets[*recordSet.Name]
+			if !ok {
+				txtSet = &route53.ResourceRecordSet{
+					Name: recordSet.Name,
+					Type: aws.String(route53.RRTypeTxt),
+					TTL:  recordSet.TTL,
+				}
+				txtSets[*recordSet.Name] = txtSet
+				changes = append(changes, &route53.Change{
+					Action:            aws.String(route53.ChangeActionUpsert),
+					ResourceRecordSet: txtSet,
+				})
+			}
+			txtSet.ResourceRecords = append(txtSet.ResourceRecords, &route53.ResourceRecord{
+				Value: aws.String(strconv.Quote(string(value))),
+			})
+		}
+	}
+
+	for _, change := range changes {
+		log.Printf("DEBUG: Creating record set %s", change.ResourceRecordSet)
+	}
+	return changes
+}
+
+// logPlannedChange logs the full detail of a change that is not going to be applied
+func logPlannedChange(change *route53.Change) {
+	recordSet := change.ResourceRecordSet
+	var values []string
+	for _, record := range recordSet.ResourceRecords {
+		values = append(values, *record.Value)
+	}
+	if recordSet.AliasTarget != nil {
+		values = append(values, "alias:"+*recordSet.AliasTarget.DNSName)
+	}
+	var ttl int64
+	if recordSet.TTL != nil {
+		ttl = *recordSet.TTL
+	}
+	log.Printf("Planned change: action=%s name=%s type=%s value=%s ttl=%d",
+		*change.Action, *recordSet.Name, *recordSet.Type, strings.Join(values, ","), ttl)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/dns"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func TestRecordWeight(t *testing.T) {
+	tests := []struct {
+		name       string
+		weightedBy string
+		cpus       float64
+		labels     map[string]string
+		want       int64
+	}{
+		{name: "flat", weightedBy: "flat", cpus: 0.5, want: DEFAULT_WEIGHT},
+		{name: "half a CPU", weightedBy: "cpu", cpus: 0.5, want: 50},
+		{name: "rounded", weightedBy: "cpu", cpus: 0.256, want: 26},
+		{name: "at least 1", weightedBy: "cpu", cpus: 0.001, want: 1},
+		{name: "at most the maximum weight", weightedBy: "cpu", cpus: 4, want: MAX_WEIGHT},
+		{name: "label takes precedence", weightedBy: "cpu", cpus: 0.5, labels: map[string]string{WEIGHT_LABEL: "7"}, want: 7},
+		{name: "invalid label is ignored", weightedBy: "cpu", cpus: 0.5, labels: map[string]string{WEIGHT_LABEL: "heavy"}, want: 50},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := parseTestConfig(t, "-weighted-by", test.weightedBy)
+			app := runningApp("10.0.0.1")
+			app.CPUs = test.cpus
+			if test.labels != nil {
+				app.Labels = &test.labels
+			}
+			if got := recordWeight(cfg, app); got != test.want {
+				t.Errorf("Expected weight %d, got %d", test.want, got)
+			}
+		})
+	}
+}
+
+func TestUpdateRecordsWeightedByCPU(t *testing.T) {
+	cfg := parseTestConfig(t, "-weighted-by", "cpu")
+	client := newMockRoute53()
+	app := runningApp("10.0.0.1", "10.0.0.2")
+	app.CPUs = 1.5
+
+	appErr := updateRecords(context.Background(), cfg, newMockMarathonClient(app), newMockRoute53Provider(client), testTarget(cfg))
+	if appErr != nil {
+		t.Fatalf("Update failed: %v", appErr.Err)
+	}
+
+	weighted := 0
+	for _, recordSet := range client.recordSets {
+		if recordSet.SetIdentifier == nil || aws.StringValue(recordSet.Type) != route53.RRTypeA {
+			continue
+		}
+		weighted++
+		if weight := aws.Int64Value(recordSet.Weight); weight != 150 {
+			t.Errorf("Expected %s to have weight 150, got %d", aws.StringValue(recordSet.SetIdentifier), weight)
+		}
+	}
+	if weighted != 2 {
+		t.Errorf("Expected 2 weighted record sets, got %d", weighted)
+	}
+}
+
+func TestEnumeratedAAAARecordNames(t *testing.T) {
+	if got := ipv6EnumeratedRecordSet("marathon-lb.example.com", "v6"); got != "marathon-lb-v6.example.com" {
+		t.Errorf("Expected marathon-lb-v6.example.com, got %s", got)
+	}
+
+	tests := []struct {
+		name       string
+		aaaaPrefix string
+		want       bool
+	}{
+		{"marathon-lb.example.com", "v6", true},
+		{"marathon-lb-1.example.com", "v6", true},
+		{"marathon-lb-v6-1.example.com", "v6", true},
+		{"marathon-lb-ipv6-1.example.com", "v6", false},
+		{"marathon-lb-ipv6-1.example.com", "ipv6", true},
+		{"marathon-lb-v6.example.com", "v6", false},
+	}
+	for _, test := range tests {
+		if got := dns.IsManagedRecordName("marathon-lb.example.com", test.name, test.aaaaPrefix); got != test.want {
+			t.Errorf("IsManagedRecordName(%s, %s) = %v, want %v", test.name, test.aaaaPrefix, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+)
+
+// reloadableFlags are the flags a reload of the config file applies, changes to all other flags
+// require a restart, e.g. because they configure the Marathon client or the DNS provider
+var reloadableFlags = map[string]bool{
+	"record-set":      true,
+	"record-set-type": true,
+	"max-ips":         true,
+	"exclude-ips":     true,
+}
+
+// liveConfig holds the config the updates run with, which SIGHUP reloads from the config file
+var liveConfig = struct {
+	sync.Mutex
+	cfg config.Config
+}{}
+
+// currentConfig returns the config the next update runs with
+func currentConfig() config.Config {
+	liveConfig.Lock()
+	defer liveConfig.Unlock()
+	return liveConfig.cfg
+}
+
+func setCurrentConfig(cfg config.Config) {
+	liveConfig.Lock()
+	defer liveConfig.Unlock()
+	liveConfig.cfg = cfg
+}
+
+// watchReloads reloads the config file whenever the updater receives SIGHUP, until ctx is cancelled
+func watchReloads(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			reloadConfig()
+		}
+	}
+}
+
+// reloadConfig parses the command line and the config file again and applies the changes to the
+// reloadableFlags, logging a warning for changes to other flags. An invalid config is not applied.
+func reloadConfig() {
+	log.Println("Received SIGHUP, reloading the config file")
+	fs := flag.NewFlagSet(flag.CommandLine.Name(), flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	reloaded, err := config.ParseConfig(fs, os.Args[1:])
+	if err != nil {
+		log.Printf("WARNING: Unable to reload the config file, keeping the current config: %v", err)
+		return
+	}
+
+	var changed []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if current := flag.CommandLine.Lookup(f.Name); current != nil && current.Value.String() != f.Value.String() {
+			changed = append(changed, f.Name)
+		}
+	})
+	if len(changed) == 0 {
+		log.Println("Reloaded the config file, nothing changed")
+		return
+	}
+	sort.Strings(changed)
+
+	// Updates copy the config when they start, so waiting for the running one keeps a cycle consistent
+	updateLock.Lock()
+	defer updateLock.Unlock()
+	cfg := currentConfig()
+	for _, name := range changed {
+		if !reloadableFlags[name] {
+			// The value isn't logged as it may be a secret
+			log.Printf("WARNING: %s changed in the config file, which requires a restart", name)
+			continue
+		}
+		value := fs.Lookup(name).Value.String()
+		log.Printf("Reloaded %s: %s", name, value)
+		switch name {
+		case "record-set":
+			cfg.AppRecordSets = reloaded.AppRecordSets
+			cfg.AppGroupRecordSet = reloaded.AppGroupRecordSet
+		case "record-set-type":
+			cfg.RecordSetTypes = reloaded.RecordSetTypes
+		case "max-ips":
+			cfg.MaxIPs = reloaded.MaxIPs
+		case "exclude-ips":
+			cfg.ExcludeIPs = reloaded.ExcludeIPs
+		}
+		// The next reload compares against the applied value
+		flag.CommandLine.Set(name, value)
+	}
+	setCurrentConfig(cfg)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+	"github.com/DigDug101/marathon-dns-updater/internal/dns"
+)
+
+// appliedChanges counts the record changes applied by updates, so a resync can tell whether the
+// records were out of date
+var appliedChanges atomic.Int64
+
+// runResyncs updates the records of every app every force-resync-interval until ctx is cancelled,
+// whether or not there were events and without debouncing, so that records changed outside of the
+// updater, e.g. in the AWS console, are corrected. A resync waits for a running update to finish and
+// runs with the current config, which SIGHUP may have reloaded since.
+func runResyncs(ctx context.Context, cfg config.Config, client MarathonClient, provider dns.DNSProvider, leader *leaderLock) {
+	ticker := time.NewTicker(cfg.ForceResyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		log.Println("Forcing a resync of the records of every app")
+		updateLock.Lock()
+		before := appliedChanges.Load()
+		ran := true
+		resync := func() {
+			updateCycle(ctx, currentConfig(), client, provider)
+		}
+		if leader != nil {
+			ran = leader.runAsLeader(resync)
+		} else {
+			resync()
+		}
+		corrected := appliedChanges.Load() - before
+		updateLock.Unlock()
+
+		if !ran {
+			continue
+		}
+		if corrected > 0 {
+			log.Printf("WARNING: Resync found records out of date, corrected them with %d changes", corrected)
+		} else {
+			log.Println("Resync found the records up to date")
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+	"github.com/DigDug101/marathon-dns-updater/internal/dns"
+)
+
+// scaleDown is when an app was first seen without running tasks and whether its records have been
+// deleted since
+type scaleDown struct {
+	since   time.Time
+	deleted bool
+}
+
+// scaledDownApps tracks the apps without running tasks for scale-down-guard, by statusKey
+var scaledDownApps = struct {
+	sync.Mutex
+	apps map[string]scaleDown
+}{apps: map[string]scaleDown{}}
+
+// guardScaleDown keeps the records of target while its app has no running tasks, e.g. while it is
+// stopped for a while, instead of failing the update. Once the app has had no running tasks for
+// scale-down-guard-ttl, if set, its records are deleted.
+func guardScaleDown(ctx context.Context, cfg config.Config, provider dns.DNSProvider, target config.AppRecordSet) *appError {
+	scaledDownApps.Lock()
+	defer scaledDownApps.Unlock()
+
+	key := statusKey(target)
+	state, ok := scaledDownApps.apps[key]
+	if !ok {
+		state = scaleDown{since: time.Now()}
+		scaledDownApps.apps[key] = state
+	}
+	if state.deleted {
+		return nil
+	}
+
+	idle := time.Since(state.since)
+	if cfg.ScaleDownGuardTTL == 0 || idle < cfg.ScaleDownGuardTTL {
+		log.Printf("WARNING: No running tasks found for appId: %s for %v, keeping the records of %s",
+			target.AppID, idle.Round(time.Second), target.RecordSet)
+		return nil
+	}
+
+	log.Printf("WARNING: No running tasks found for appId: %s for scale-down-guard-ttl %v, deleting the records of %s",
+		target.AppID, cfg.ScaleDownGuardTTL, target.RecordSet)
+	if err := deleteRecords(ctx, cfg, provider, target); err != nil {
+		return err
+	}
+	if !cfg.DryRun {
+		currentStatus.remove(target)
+		state.deleted = true
+		scaledDownApps.apps[key] = state
+	}
+	return nil
+}
+
+// clearScaleDown forgets that the app of target had no running tasks once it has some again
+func clearScaleDown(target config.AppRecordSet) {
+	scaledDownApps.Lock()
+	defer scaledDownApps.Unlock()
+	delete(scaledDownApps.apps, statusKey(target))
+}
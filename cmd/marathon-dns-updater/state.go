@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// appState is the set of IPs an app's records pointed at after its last successful update
+type appState struct {
+	IPs   []string `json:"ips"`
+	IPv6s []string `json:"ipv6s,omitempty"`
+}
+
+// stateFile persists the appState of every app in a JSON file so that the last known good records
+// are kept rather than the updater exiting when Marathon can't be reached
+type stateFile struct {
+	path string
+	mu   sync.Mutex
+	apps map[string]appState
+}
+
+// lastKnownState is nil unless a state-file is configured
+var lastKnownState *stateFile
+
+// loadStateFile reads the state file at path, a missing file is treated as an empty state
+func loadStateFile(path string) (*stateFile, error) {
+	state := &stateFile{
+		path: path,
+		apps: map[string]appState{},
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &state.apps); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *stateFile) get(appID string) (appState, bool) {
+	if s == nil {
+		return appState{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.apps[appID]
+	return state, ok
+}
+
+// set records the state of an app and rewrites the file. The file is replaced atomically so it
+// is never left partially written.
+func (s *stateFile) set(appID string, state appState) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apps[appID] = state
+
+	data, err := json.MarshalIndent(s.apps, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+)
+
+// appStatus describes the records of an app as of its last successful update and the number of its
+// tasks left out by the latest update because of failing health checks
+type appStatus struct {
+	AppID                  string              `json:"app_id"`
+	RecordSet              string              `json:"record_set"`
+	HostedZoneID           string              `json:"hosted_zone_id,omitempty"`
+	LastSuccessfulUpdate   *time.Time          `json:"last_successful_update"`
+	ActiveIPs              map[string][]string `json:"active_ips"`
+	TasksExcludedUnhealthy int                 `json:"tasks_excluded_unhealthy"`
+}
+
+// updaterStatus is the in memory state served by /status and /ready
+type updaterStatus struct {
+	mu   sync.RWMutex
+	apps map[string]appStatus
+	// firstSuccessfulUpdate is kept when the records of its app are deleted later on
+	firstSuccessfulUpdate *time.Time
+	// marathonHost is the Marathon host whose event stream is connected, or was last
+	marathonHost string
+}
+
+var currentStatus = newUpdaterStatus()
+
+func newUpdaterStatus() *updaterStatus {
+	return &updaterStatus{apps: map[string]appStatus{}}
+}
+
+// recordUpdate stores the IPs the records of each enabled record set type point at after a
+// successful update of an app
+func (s *updaterStatus) recordUpdate(cfg config.Config, target config.AppRecordSet, taskIps map[string]string, taskIpv6s map[string]string) {
+	activeIPs := map[string][]string{}
+	for recordSetType, ips := range map[string]map[string]string{
+		config.WEIGHTED:        taskIps,
+		config.ENUMERATED:      taskIps,
+		config.WEIGHTED_IPV6:   taskIpv6s,
+		config.ENUMERATED_IPV6: taskIpv6s,
+	} {
+		if cfg.RecordSetTypes[recordSetType] {
+			activeIPs[recordSetType] = sortedIps(ips)
+		}
+	}
+
+	now := time.Now().UTC()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	app := s.apps[statusKey(target)]
+	app.AppID, app.RecordSet, app.HostedZoneID = target.AppID, target.RecordSet, target.HostedZoneID
+	app.LastSuccessfulUpdate = &now
+	app.ActiveIPs = activeIPs
+	s.apps[statusKey(target)] = app
+	if s.firstSuccessfulUpdate == nil {
+		s.firstSuccessfulUpdate = &now
+	}
+}
+
+// recordExcludedUnhealthy stores the number of tasks of target the latest update left out because of
+// failing health checks, whether or not the update succeeds, so that running but unhealthy tasks can
+// be told apart from no running tasks at all
+func (s *updaterStatus) recordExcludedUnhealthy(target config.AppRecordSet, count int) {
+	appMetrics.tasksExcludedUnhealthy.WithLabelValues(target.AppID).Set(float64(count))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	app := s.apps[statusKey(target)]
+	app.AppID, app.RecordSet, app.HostedZoneID = target.AppID, target.RecordSet, target.HostedZoneID
+	app.TasksExcludedUnhealthy = count
+	s.apps[statusKey(target)] = app
+}
+
+// setMarathonHost stores the Marathon host the event stream is connected to
+func (s *updaterStatus) setMarathonHost(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marathonHost = host
+}
+
+// remove drops target from the status, e.g. once its records have been deleted
+func (s *updaterStatus) remove(target config.AppRecordSet) {
+	appMetrics.tasksExcludedUnhealthy.DeleteLabelValues(target.AppID)
+	appMetrics.recordsActive.DeleteLabelValues(target.AppID, target.RecordSet)
+	appMetrics.recordsAdded.DeleteLabelValues(target.AppID, target.RecordSet)
+	appMetrics.recordsRemoved.DeleteLabelValues(target.AppID, target.RecordSet)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.apps, statusKey(target))
+}
+
+// lastState returns the IPs of the records of target as of its last successful update
+func (s *updaterStatus) lastState(target config.AppRecordSet) (appState, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	app, ok := s.apps[statusKey(target)]
+	if !ok || app.LastSuccessfulUpdate == nil {
+		return appState{}, false
+	}
+
+	state := appState{IPs: app.ActiveIPs[config.WEIGHTED], IPv6s: app.ActiveIPs[config.WEIGHTED_IPV6]}
+	if state.IPs == nil {
+		state.IPs = app.ActiveIPs[config.ENUMERATED]
+	}
+	if state.IPv6s == nil {
+		state.IPv6s = app.ActiveIPs[config.ENUMERATED_IPV6]
+	}
+	return state, true
+}
+
+// statusKey identifies target in the status, an app can have several record sets with zone-mappings
+func statusKey(target config.AppRecordSet) string {
+	return target.HostedZoneID + " " + target.RecordSet + " " + target.AppID
+}
+
+// handler serves the status of every app as JSON, or 503 until an update has succeeded
+func (s *updaterStatus) handler(hostedZoneID string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		response := struct {
+			HostedZoneID         string      `json:"hosted_zone_id"`
+			MarathonHost         string      `json:"marathon_host,omitempty"`
+			LastSuccessfulUpdate *time.Time  `json:"last_successful_update"`
+			Apps                 []appStatus `json:"apps"`
+		}{
+			HostedZoneID: hostedZoneID,
+			MarathonHost: s.marathonHost,
+			Apps:         []appStatus{},
+		}
+		for _, app := range s.apps {
+			if app.LastSuccessfulUpdate != nil && (response.LastSuccessfulUpdate == nil || app.LastSuccessfulUpdate.After(*response.LastSuccessfulUpdate)) {
+				response.LastSuccessfulUpdate = app.LastSuccessfulUpdate
+			}
+			response.Apps = append(response.Apps, app)
+		}
+		s.mu.RUnlock()
+
+		sort.Slice(response.Apps, func(i, j int) bool {
+			if response.Apps[i].AppID != response.Apps[j].AppID {
+				return response.Apps[i].AppID < response.Apps[j].AppID
+			}
+			return response.Apps[i].RecordSet < response.Apps[j].RecordSet
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		if response.LastSuccessfulUpdate == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// readyHandler serves the readiness of the updater as JSON: 200 once an update has succeeded since it
+// started, 503 before. Unlike /health it doesn't check whether Marathon is reachable.
+func (s *updaterStatus) readyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		response := struct {
+			Ready                 bool       `json:"ready"`
+			Reason                string     `json:"reason,omitempty"`
+			FirstSuccessfulUpdate *time.Time `json:"first_successful_update,omitempty"`
+		}{
+			Ready:                 s.firstSuccessfulUpdate != nil,
+			FirstSuccessfulUpdate: s.firstSuccessfulUpdate,
+		}
+		s.mu.RUnlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if !response.Ready {
+			response.Reason = "No update of the records has succeeded yet"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(response)
+	}
+}
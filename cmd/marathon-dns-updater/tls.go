@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+)
+
+// newMarathonTransport returns the transport for requests to Marathon, using a client certificate,
+// CA bundle, server name and/or skipping certificate verification when they are configured
+func newMarathonTransport(cfg config.Config) (http.RoundTripper, error) {
+	if cfg.MarathonTLSCert == "" && cfg.MarathonTLSKey == "" && cfg.MarathonTLSCA == "" &&
+		cfg.MarathonTLSServerName == "" && !cfg.MarathonTLSInsecureSkipVerify {
+		return http.DefaultTransport, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.MarathonTLSServerName,
+		InsecureSkipVerify: cfg.MarathonTLSInsecureSkipVerify,
+	}
+	if cfg.MarathonTLSInsecureSkipVerify {
+		log.Printf("WARNING: marathon-tls-insecure-skip-verify is set, the certificate of Marathon is not verified")
+	}
+
+	if cfg.MarathonTLSCert != "" || cfg.MarathonTLSKey != "" {
+		if cfg.MarathonTLSCert == "" || cfg.MarathonTLSKey == "" {
+			return nil, fmt.Errorf("marathon-tls-cert and marathon-tls-key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.MarathonTLSCert, cfg.MarathonTLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load client certificate %s with key %s: %v", cfg.MarathonTLSCert, cfg.MarathonTLSKey, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.MarathonTLSCA != "" {
+		caBundle, err := ioutil.ReadFile(cfg.MarathonTLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read CA bundle %s: %v", cfg.MarathonTLSCA, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("No certificates found in CA bundle %s", cfg.MarathonTLSCA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+	"github.com/DigDug101/marathon-dns-updater/internal/dns"
+)
+
+const UPDATE_SECRET_HEADER = "X-Update-Secret"
+
+// updateLock serializes the updates of the event loop and those triggered through POST /update
+var updateLock sync.Mutex
+
+// triggerResult is the JSON response of POST /update
+type triggerResult struct {
+	Result   string             `json:"result"`
+	Error    string             `json:"error,omitempty"`
+	Duration float64            `json:"duration_seconds"`
+	Apps     []appTriggerResult `json:"apps"`
+}
+
+// appTriggerResult is the outcome of the update of the records of a single app
+type appTriggerResult struct {
+	AppID     string `json:"app_id"`
+	RecordSet string `json:"record_set"`
+	Error     string `json:"error,omitempty"`
+	Fatal     bool   `json:"fatal,omitempty"`
+}
+
+// triggerHandler serves POST /update, which updates the records of every app right away and responds
+// once the update is done. Requests need the update-secret in the X-Update-Secret header. An update
+// that is already running, whether triggered or not, is answered with a 429.
+func triggerHandler(ctx context.Context, cfg config.Config, client MarathonClient, provider dns.DNSProvider, leader *leaderLock) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(UPDATE_SECRET_HEADER)), []byte(cfg.UpdateSecret)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !updateLock.TryLock() {
+			http.Error(w, "An update is already in progress", http.StatusTooManyRequests)
+			return
+		}
+		defer updateLock.Unlock()
+
+		// The update waits for Route53 to apply the changes, which takes longer than the server's
+		// write timeout
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+		start := time.Now()
+		response := triggerResult{Result: "success", Apps: []appTriggerResult{}}
+		status := http.StatusOK
+
+		resolved, err := resolveApps(currentConfig(), client)
+		if err != nil {
+			response.Result, response.Error, status = "error", err.Error(), http.StatusInternalServerError
+		} else {
+			var errs []*appError
+			ran := true
+			update := func() {
+				errs = updateAllRecords(ctx, resolved, client, provider)
+			}
+			if leader != nil {
+				ran = leader.runAsLeader(update)
+			} else {
+				update()
+			}
+
+			if !ran {
+				response.Result, response.Error, status = "skipped", "Another instance holds the lock", http.StatusConflict
+			}
+			for idx, err := range errs {
+				target := resolved.AppRecordSets[idx]
+				result := appTriggerResult{AppID: target.AppID, RecordSet: target.RecordSet}
+				if err != nil {
+					logAppError(target.AppID, err)
+					result.Error, result.Fatal = err.Error(), err.IsFatal
+					response.Result, status = "error", http.StatusInternalServerError
+				}
+				response.Apps = append(response.Apps, result)
+			}
+		}
+		response.Duration = time.Since(start).Seconds()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	}
+}
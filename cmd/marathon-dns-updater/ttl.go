@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+)
+
+// stableUpdates counts the updates in a row that found the same IPs as the previous successful
+// update, by statusKey
+var stableUpdates = struct {
+	sync.Mutex
+	counts map[string]int
+}{counts: map[string]int{}}
+
+// adaptiveTTL returns the TTL in seconds of the records of target with adaptive-ttl. While the IPs
+// change, e.g. during a deployment, it is min-ttl to keep stale answers short. Once they haven't
+// changed for stable-cycles updates it ramps up linearly to max-ttl over as many further updates.
+func adaptiveTTL(cfg config.Config, target config.AppRecordSet, taskIps map[string]string, taskIpv6s map[string]string) int64 {
+	_, added, removed := publishedChanges(target, taskIps, taskIpv6s)
+	changed := len(added) > 0 || len(removed) > 0
+
+	stableUpdates.Lock()
+	key := statusKey(target)
+	if changed {
+		stableUpdates.counts[key] = 0
+	} else {
+		stableUpdates.counts[key]++
+	}
+	stable := stableUpdates.counts[key]
+	stableUpdates.Unlock()
+
+	minTTL, maxTTL := int64(cfg.MinTTL/time.Second), int64(cfg.MaxTTL/time.Second)
+	ttl := minTTL
+	if stable >= cfg.StableCycles {
+		ttl = minTTL + (maxTTL-minTTL)*int64(stable-cfg.StableCycles+1)/int64(cfg.StableCycles)
+		if ttl > maxTTL {
+			ttl = maxTTL
+		}
+	}
+	log.Printf("DEBUG: Using TTL %d for %s after %d updates without changes", ttl, target.RecordSet, stable)
+	return ttl
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// taskWarmup tracks when the IPs of running tasks were first seen, so that new tasks are only added
+// to DNS once they have been running for task-warmup-delay
+type taskWarmup struct {
+	mu        sync.Mutex
+	firstSeen map[string]map[string]time.Time
+}
+
+var tasksWarmup = &taskWarmup{firstSeen: map[string]map[string]time.Time{}}
+
+// warmupDone receives once the held back tasks of an app have warmed up, which triggers an update
+var warmupDone = make(chan struct{}, 1)
+
+// filter returns ips without the IPs first seen less than delay ago and the number of IPs left out.
+// Published IPs are never held back, so a restart doesn't take running tasks out of DNS. IPs that
+// are no longer running are forgotten. key identifies the app and record type the ips are of.
+func (w *taskWarmup) filter(key string, ips map[string]string, published map[string]bool, delay time.Duration) (map[string]string, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	previous := w.firstSeen[key]
+	seen := map[string]time.Time{}
+	warm := map[string]string{}
+	var wait time.Duration
+	for ip, value := range ips {
+		firstSeen, ok := previous[ip]
+		if !ok {
+			firstSeen = now
+		}
+		seen[ip] = firstSeen
+
+		remaining := delay - now.Sub(firstSeen)
+		if published[ip] || remaining <= 0 {
+			warm[ip] = value
+			continue
+		}
+		log.Printf("Holding back %s for %v until its task has warmed up", ip, remaining.Round(time.Second))
+		if wait == 0 || remaining < wait {
+			wait = remaining
+		}
+	}
+	w.firstSeen[key] = seen
+
+	if wait > 0 {
+		time.AfterFunc(wait, func() {
+			select {
+			case warmupDone <- struct{}{}:
+			default:
+			}
+		})
+	}
+	return warm, len(ips) - len(warm)
+}
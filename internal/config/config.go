@@ -0,0 +1,1112 @@
+// Package config holds the configuration of the updater, parsed from its flags, environment and config
+// file
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings of the updater, see NewConfigFromFlags for the flags they are read from
+type Config struct {
+	MarathonHost                  string
+	MarathonHosts                 []string
+	ConfigFile                    string
+	HostedZoneID                  string
+	AppRecordSets                 []AppRecordSet
+	RecordSetTypes                map[string]bool
+	AdminHTTPPort                 string
+	DNSProvider                   string
+	CloudflareAPIToken            string
+	WeightedTTL                   int64
+	EnumeratedTTL                 int64
+	AdaptiveTTL                   bool
+	MinTTL                        time.Duration
+	MaxTTL                        time.Duration
+	StableCycles                  int
+	EnumeratedStartIndex          int
+	ManagedIdentifierPrefixes     []string
+	AAAAEnumeratedPrefix          string
+	AWSRegion                     string
+	MinConsecutiveFailures        int
+	Route53MaxRetries             int
+	Route53BaseBackoff            time.Duration
+	DryRun                        bool
+	DynamoDBLockTable             string
+	WeightedBy                    string
+	CNAMETarget                   string
+	StateFile                     string
+	AssumeRoleArn                 string
+	AssumeRoleSessionName         string
+	Route53RPS                    float64
+	Debounce                      time.Duration
+	MaxPendingEvents              int
+	MaxEventBatch                 int
+	MarathonTLSCert               string
+	MarathonTLSKey                string
+	MarathonTLSCA                 string
+	MarathonTLSServerName         string
+	MarathonTLSInsecureSkipVerify bool
+	MarathonUser                  string
+	MarathonPassword              string
+	AdminUser                     string
+	AdminPassword                 string
+	HealthEndpointPublic          bool
+	MarathonOAuthToken            string
+	Once                          bool
+	LogFormat                     string
+	LogLevel                      string
+	AliasTarget                   string
+	AliasHostedZone               string
+	SSEReconnectDelay             time.Duration
+	StartupRetryMaxWait           time.Duration
+	SSEMaxReconnectDelay          time.Duration
+	SSEMaxReconnectAttempts       int
+	GCPProject                    string
+	GCPManagedZone                string
+	CreateTXTRecords              bool
+	MaxIPs                        int
+	PreferExisting                bool
+	RecordSetComment              *template.Template
+	AppGroup                      string
+	AppGroupRecordSet             string
+	AppIDRegex                    *regexp.Regexp
+	CreateHealthChecks            bool
+	HealthCheckPort               int64
+	HealthCheckPath               string
+	HealthCheckProtocol           string
+	HealthCheckTags               map[string]string
+	StartupSync                   bool
+	FilterLabels                  []labelFilter
+	LabelSelectors                []labelFilter
+	PlanOutput                    string
+	AuditLog                      string
+	AuditLogMaxSize               int64
+	RecordValue                   string
+	RecordType                    string
+	PollInterval                  time.Duration
+	ForceResyncInterval           time.Duration
+	FailoverSecondaryIP           string
+	ExcludeIPs                    string
+	AzureSubscriptionID           string
+	AzureResourceGroup            string
+	AzureDNSZoneName              string
+	ConsulAddr                    string
+	ConsulToken                   string
+	ConsulDC                      string
+	ListConcurrency               int
+	Route53WaitTimeout            time.Duration
+	Route53EndpointURL            string
+	UpdateTimeout                 time.Duration
+	MinHealthyTasks               int
+	NotifyURL                     string
+	NotifyTimeout                 time.Duration
+	NotifyRetries                 int
+	UseMesosDNS                   bool
+	Route53Concurrency            int
+	RecordSetPrefix               string
+	RecordSetSuffix               string
+	UpdateSecret                  string
+	PrivateZone                   bool
+	EnablePprof                   bool
+	WatchDeployments              bool
+	NormalizeWeights              bool
+	GeoContinentCode              string
+	GeoCountryCode                string
+	GeoDefault                    bool
+	TaskWarmupDelay               time.Duration
+	ScaleDownGuard                bool
+	ScaleDownGuardTTL             time.Duration
+}
+
+// AppRecordSet pairs a marathon-lb app with the record set pointing at its tasks. Record sets from
+// zone-mappings have their own hosted zone and record set types, the others use those of the Config.
+type AppRecordSet struct {
+	AppID          string
+	RecordSet      string
+	HostedZoneID   string
+	RecordSetTypes map[string]bool
+}
+
+// ForTarget returns cfg with the hosted zone and record set types of target, if it has its own
+func (cfg Config) ForTarget(target AppRecordSet) Config {
+	if target.HostedZoneID != "" {
+		cfg.HostedZoneID = target.HostedZoneID
+	}
+	if target.RecordSetTypes != nil {
+		cfg.RecordSetTypes = target.RecordSetTypes
+	}
+	return cfg
+}
+
+// DecoratedRecordSet returns name with record-set-prefix in front of it and record-set-suffix after
+// its first label, e.g. staging-lb-internal.example.com for lb.example.com
+func DecoratedRecordSet(cfg Config, name string) string {
+	parts := strings.SplitN(name, ".", 2)
+	parts[0] = cfg.RecordSetPrefix + parts[0] + cfg.RecordSetSuffix
+	return strings.Join(parts, ".")
+}
+
+// ValidateRecordSetName returns an error if name is too long for a DNS name or one of its labels is
+// too long for a DNS label
+func ValidateRecordSetName(name string) error {
+	name = strings.TrimSuffix(name, ".")
+	if len(name) > MAX_NAME_LENGTH {
+		return fmt.Errorf("Record set %s is longer than %d characters", name, MAX_NAME_LENGTH)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) > MAX_LABEL_LENGTH {
+			return fmt.Errorf("Label %s of record set %s is longer than %d characters", label, name, MAX_LABEL_LENGTH)
+		}
+	}
+	return nil
+}
+
+// geoContinentCodes are the continents Route53 geolocation records accept
+var geoContinentCodes = map[string]bool{"AF": true, "AN": true, "AS": true, "EU": true, "NA": true, "OC": true, "SA": true}
+
+// HostedZoneIds returns the ids of the hosted zones the updater manages records in
+func HostedZoneIds(cfg Config) []string {
+	zoneIds := []string{cfg.HostedZoneID}
+	seen := map[string]bool{cfg.HostedZoneID: true}
+	for _, target := range cfg.AppRecordSets {
+		if target.HostedZoneID != "" && !seen[target.HostedZoneID] {
+			seen[target.HostedZoneID] = true
+			zoneIds = append(zoneIds, target.HostedZoneID)
+		}
+	}
+	return zoneIds
+}
+
+// labelFilter matches apps with the label Key, and with HasValue only if its value is Value
+type labelFilter struct {
+	Key      string
+	Value    string
+	HasValue bool
+}
+
+// MatchesLabels reports whether labels satisfy every filter
+func MatchesLabels(filters []labelFilter, labels *map[string]string) bool {
+	for _, filter := range filters {
+		if labels == nil {
+			return false
+		}
+		value, ok := (*labels)[filter.Key]
+		if !ok || (filter.HasValue && value != filter.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLabelFilters parses the key or key=value label filters of the flag name
+func parseLabelFilters(name string, values listFlag) ([]labelFilter, error) {
+	var filters []labelFilter
+	for _, filter := range strings.Split(values.String(), ",") {
+		if filter == "" {
+			continue
+		}
+		parts := strings.SplitN(filter, "=", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("Invalid %s %q, expected key or key=value", name, filter)
+		}
+		labelFilter := labelFilter{Key: parts[0]}
+		if len(parts) == 2 {
+			labelFilter.Value, labelFilter.HasValue = parts[1], true
+		}
+		filters = append(filters, labelFilter)
+	}
+	return filters, nil
+}
+
+// listFlag is a flag that can be repeated, its values are joined with commas
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseZoneMappings parses zoneId:recordSet:types tuples separated by commas. Since the types are
+// comma separated themselves, an entry without a colon is another type of the previous tuple. A record
+// set can only be mapped once per zone.
+func parseZoneMappings(value string, appId string) ([]AppRecordSet, error) {
+	var mappings []AppRecordSet
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, ":") {
+			if len(mappings) == 0 || entry == "" {
+				return nil, fmt.Errorf("Invalid zone-mappings entry %q, expected zoneId:recordSet:types", entry)
+			}
+			mappings[len(mappings)-1].RecordSetTypes[strings.ToLower(entry)] = true
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("Invalid zone-mappings entry %q, expected zoneId:recordSet:types", entry)
+		}
+		key := parts[0] + " " + strings.ToLower(strings.TrimSuffix(parts[1], "."))
+		if seen[key] {
+			return nil, fmt.Errorf("Record set %s is mapped more than once to zone %s", parts[1], parts[0])
+		}
+		seen[key] = true
+		mappings = append(mappings, AppRecordSet{
+			AppID:          appId,
+			RecordSet:      parts[1],
+			HostedZoneID:   parts[0],
+			RecordSetTypes: map[string]bool{strings.ToLower(parts[2]): true},
+		})
+	}
+	return mappings, nil
+}
+
+// NewConfigFromFlags parses the command line flags, and the config file if one is given, into a
+// validated Config
+func NewConfigFromFlags() (Config, error) {
+	return ParseConfig(flag.CommandLine, os.Args[1:])
+}
+
+// ParseConfig defines the flags on fs and parses args, and the config file if one is given, into a
+// validated Config. A fresh FlagSet parses the config again, e.g. to reload the config file.
+func ParseConfig(fs *flag.FlagSet, args []string) (Config, error) {
+	var cfg Config
+	var appId, recordSetName, recordSetType, appIds, appIDRegex, configFile string
+	var debounceMs int
+	var recordSetComment, hostedZoneIDParam, managedIdentifierPrefixes, marathonHosts string
+	var zoneMappings, filterLabels, labelSelectors, healthCheckTags listFlag
+
+	fs.StringVar(&cfg.MarathonHost, "marathon-host", "http://marathon.mesos:8080", "HTTP endpoint of Marathon service")
+	fs.StringVar(&marathonHosts, "marathon-hosts", "", "Comma separated HTTP endpoints of the Marathon masters, tried in turn when one is unreachable, overrides marathon-host")
+	fs.StringVar(&appId, "app-id", "marathon-lb", "Marathon app id of marathon-lb service")
+	fs.StringVar(&cfg.HostedZoneID, "hosted-zone-id", "", "Route53 Hosted Zone or Cloudflare zone id")
+	fs.StringVar(&recordSetName, "record-set", "marathon-lb.example.com", "Record set to update")
+	fs.StringVar(&cfg.RecordSetSuffix, "record-set-suffix", "", "Suffix of the first label of all record sets, e.g. -internal for lb-internal.example.com and lb-internal-1.example.com")
+	fs.StringVar(&cfg.RecordSetPrefix, "record-set-prefix", "", "Prefix of the names of all record sets, e.g. staging- for staging-lb.example.com and its enumerated records")
+	fs.StringVar(&recordSetType, "record-set-type", "weighted,enumerated", "Comma separated list of record set types: weighted, enumerated, weighted-ipv6, enumerated-ipv6, srv, latency, geo, multivalue, failover-primary, failover-secondary")
+	fs.StringVar(&cfg.GeoContinentCode, "geo-continent-code", "", "Continent of the geo records: AF, AN, AS, EU, NA, OC or SA")
+	fs.StringVar(&cfg.GeoCountryCode, "geo-country-code", "", "ISO 3166-1 alpha-2 country of the geo records, instead of geo-continent-code")
+	fs.BoolVar(&cfg.GeoDefault, "geo-default", false, "Also create a geo record for all locations not matched by another geo record")
+	fs.StringVar(&cfg.AWSRegion, "aws-region", "", "AWS region of the tasks for latency records, defaults to $AWS_REGION")
+	fs.StringVar(&cfg.AdminHTTPPort, "admin-http-port", "8080", "http port for admin/health check")
+	fs.StringVar(&cfg.DNSProvider, "dns-provider", ROUTE53, "DNS provider to update: route53, cloudflare, google, azure, consul")
+	fs.StringVar(&cfg.CloudflareAPIToken, "cloudflare-api-token", "", "Cloudflare API token, defaults to $CLOUDFLARE_API_TOKEN")
+	fs.Int64Var(&cfg.WeightedTTL, "weighted-ttl", 60, "TTL in seconds of weighted records")
+	fs.Int64Var(&cfg.EnumeratedTTL, "enumerated-ttl", 60, "TTL in seconds of enumerated records")
+	fs.BoolVar(&cfg.AdaptiveTTL, "adaptive-ttl", false, "Use min-ttl for records whose IPs changed and ramp up to max-ttl once they are stable, instead of weighted-ttl and enumerated-ttl")
+	fs.DurationVar(&cfg.MinTTL, "min-ttl", 10*time.Second, "TTL of the records of adaptive-ttl while their IPs change")
+	fs.DurationVar(&cfg.MaxTTL, "max-ttl", 300*time.Second, "TTL the records of adaptive-ttl ramp up to once their IPs are stable")
+	fs.IntVar(&cfg.StableCycles, "stable-cycles", 3, "Number of updates without changes after which adaptive-ttl ramps up the TTL, over as many updates")
+	fs.IntVar(&cfg.EnumeratedStartIndex, "enumerated-start-index", 1, "Number of the first enumerated record, e.g. 0 for marathon-lb-0.example.com")
+	fs.StringVar(&managedIdentifierPrefixes, "managed-identifier-prefix", strings.Join(defaultManagedIdentifierPrefixes, ","), "Comma separated prefixes of the set identifiers of the Route53 record sets the updater may delete, others are never touched. Record sets without a set identifier are only deleted if they are named after the record set or one of its enumerated names")
+	fs.StringVar(&cfg.AAAAEnumeratedPrefix, "aaaa-enumerated-prefix", "ipv6", "Label part that sets the names of enumerated AAAA records apart, e.g. marathon-lb-ipv6-1.example.com")
+	fs.IntVar(&cfg.MinConsecutiveFailures, "min-consecutive-failures", 0, "Exclude tasks with a failing health check with at least this many consecutive failures, 0 disables")
+	fs.IntVar(&cfg.MinHealthyTasks, "min-healthy-tasks", 1, "Leave the records unchanged while fewer tasks of an app are healthy")
+	fs.IntVar(&cfg.Route53MaxRetries, "route53-max-retries", 3, "Number of times a failed DNS update is retried")
+	fs.DurationVar(&cfg.Route53BaseBackoff, "route53-base-backoff", 500*time.Millisecond, "Back-off before the first retry of a failed DNS update, doubled for each further retry")
+	fs.BoolVar(&cfg.DryRun, "dry-run", false, "Log the planned DNS changes without applying them")
+	fs.StringVar(&cfg.DynamoDBLockTable, "dynamodb-lock-table", "", "DynamoDB table holding the lock that elects a single updater instance to apply changes, disabled if empty")
+	fs.StringVar(&cfg.WeightedBy, "weighted-by", WEIGHTED_BY_FLAT, "How weighted records are weighted: flat (weight 10) or cpu (100 per CPU allocated to a task)")
+	fs.StringVar(&cfg.CNAMETarget, "cname-target", "", "DNS name, e.g. of an ELB, that enumerated records point at as CNAME records instead of A records to task IPs")
+	fs.StringVar(&cfg.StateFile, "state-file", "", "JSON file the IPs of the last successful update are saved to, used to keep records when Marathon is unreachable")
+	fs.StringVar(&cfg.AssumeRoleArn, "assume-role-arn", "", "ARN of an IAM role to assume for Route53 updates, e.g. in another account")
+	fs.StringVar(&cfg.AssumeRoleSessionName, "assume-role-session-name", "marathon-dns-updater", "Session name used when assuming assume-role-arn")
+	fs.DurationVar(&cfg.Route53WaitTimeout, "route53-wait-timeout", 5*time.Minute, "Maximum time to wait for a Route53 change batch to be applied before moving on")
+	fs.DurationVar(&cfg.UpdateTimeout, "update-timeout", 120*time.Second, "Maximum time a single update of the records of an app may take, including waiting for Route53")
+	fs.IntVar(&cfg.Route53Concurrency, "route53-concurrency", 1, "Maximum number of Route53 changes submitted at once across all apps")
+	fs.IntVar(&cfg.ListConcurrency, "list-concurrency", 5, "Maximum number of Route53 record set listings in flight at once, e.g. across zone-mappings")
+	fs.Float64Var(&cfg.Route53RPS, "route53-rps", 2, "Maximum number of Route53 change requests per second across all apps")
+	fs.StringVar(&cfg.Route53EndpointURL, "route53-endpoint-url", "", "Experimental, for testing only: URL of a Route53 compatible API, e.g. LocalStack, used instead of AWS")
+	fs.IntVar(&debounceMs, "debounce-ms", 2000, "Milliseconds to collect further events for after an event before updating records")
+	fs.IntVar(&cfg.MaxPendingEvents, "max-pending-events", 50, "Number of pending events that triggers an update before the debounce window has passed")
+	fs.IntVar(&cfg.MaxEventBatch, "max-event-batch", 100, "Maximum number of events covered by a single update, queued events beyond it are left for the next one")
+	fs.StringVar(&cfg.MarathonTLSCert, "marathon-tls-cert", "", "PEM client certificate for mutual TLS with Marathon")
+	fs.StringVar(&cfg.MarathonTLSKey, "marathon-tls-key", "", "PEM key of marathon-tls-cert")
+	fs.StringVar(&cfg.MarathonTLSCA, "marathon-tls-ca", "", "PEM CA bundle used to verify the Marathon server certificate")
+	fs.StringVar(&cfg.MarathonTLSServerName, "marathon-tls-server-name", "", "Server name used for SNI and to verify the Marathon server certificate, defaults to the host of marathon-host")
+	fs.BoolVar(&cfg.MarathonTLSInsecureSkipVerify, "marathon-tls-insecure-skip-verify", false, "Don't verify the Marathon server certificate, e.g. when it is self-signed")
+	fs.StringVar(&cfg.MarathonUser, "marathon-user", "", "User for HTTP basic auth with Marathon, defaults to $MARATHON_USER")
+	fs.StringVar(&cfg.MarathonPassword, "marathon-password", "", "Password for HTTP basic auth with Marathon, defaults to $MARATHON_PASSWORD")
+	fs.StringVar(&cfg.AdminUser, "admin-user", "", "User for HTTP basic auth required by the admin HTTP server, which is open if empty")
+	fs.StringVar(&cfg.AdminPassword, "admin-password", "", "Password for HTTP basic auth required by the admin HTTP server, defaults to $ADMIN_PASSWORD")
+	fs.BoolVar(&cfg.HealthEndpointPublic, "health-endpoint-public", false, "Serve /health without admin-user auth, e.g. for load balancer health checks")
+	fs.StringVar(&cfg.MarathonOAuthToken, "marathon-oauth-token", "", "DC/OS authentication token sent to Marathon as Authorization: token=<value>, defaults to $MARATHON_OAUTH_TOKEN")
+	fs.BoolVar(&cfg.Once, "once", false, "Update records a single time and exit: 0 on success, 1 on a non-fatal and 2 on a fatal error")
+	fs.StringVar(&cfg.LogFormat, "log-format", LOG_FORMAT_TEXT, "Format of log messages: text or json")
+	fs.StringVar(&cfg.LogLevel, "log-level", "info", "Minimum level of log messages: debug, info, warn or error")
+	fs.StringVar(&cfg.AliasTarget, "alias-target", "", "DNS name of an ALB/NLB that weighted records alias instead of pointing at task IPs")
+	fs.StringVar(&cfg.AliasHostedZone, "alias-hosted-zone", "", "Hosted zone id of the load balancer given by alias-target")
+	fs.DurationVar(&cfg.StartupRetryMaxWait, "startup-retry-max-wait", 5*time.Minute, "Maximum time to wait for Marathon to respond at startup, retrying with a growing delay, before exiting, 0 disables the check")
+	fs.DurationVar(&cfg.SSEReconnectDelay, "sse-reconnect-delay", 5*time.Second, "Delay before reconnecting to the Marathon event stream, doubled for each further attempt")
+	fs.DurationVar(&cfg.SSEMaxReconnectDelay, "sse-max-reconnect-delay", 60*time.Second, "Maximum delay between attempts to reconnect to the Marathon event stream")
+	fs.IntVar(&cfg.SSEMaxReconnectAttempts, "sse-max-reconnect-attempts", 0, "Exit after this many failed attempts in a row to reconnect to the Marathon event stream, 0 is unlimited")
+	fs.StringVar(&cfg.GCPProject, "gcp-project", "", "Google Cloud project of the Cloud DNS managed zone")
+	fs.StringVar(&cfg.GCPManagedZone, "gcp-managed-zone", "", "Cloud DNS managed zone to update, defaults to hosted-zone-id")
+	fs.StringVar(&cfg.AzureSubscriptionID, "azure-subscription-id", "", "Azure subscription of the Azure DNS zone")
+	fs.StringVar(&cfg.AzureResourceGroup, "azure-resource-group", "", "Azure resource group of the Azure DNS zone")
+	fs.StringVar(&cfg.AzureDNSZoneName, "azure-dns-zone-name", "", "Azure DNS zone to update, e.g. example.com, defaults to hosted-zone-id")
+	fs.StringVar(&cfg.ConsulAddr, "consul-addr", "", "Address of the Consul agent, defaults to $CONSUL_HTTP_ADDR or 127.0.0.1:8500")
+	fs.StringVar(&cfg.ConsulToken, "consul-token", "", "Consul ACL token, defaults to $CONSUL_HTTP_TOKEN")
+	fs.StringVar(&cfg.ConsulDC, "consul-dc", "", "Consul datacenter to register the tasks in, defaults to that of the agent")
+	fs.BoolVar(&cfg.CreateTXTRecords, "create-txt-records", false, "Create TXT records next to the A and AAAA records with the task id, app version and staging time of their IPs")
+	fs.IntVar(&cfg.MaxIPs, "max-ips", 0, "Maximum number of IPs per record type registered for an app, 0 is unlimited")
+	fs.BoolVar(&cfg.PreferExisting, "prefer-existing", true, "With max-ips, keep the IPs already registered over the IPs of new tasks")
+	fs.StringVar(&recordSetComment, "record-set-comment", "Updated records for {{.RecordSet}}", "Go template of the Route53 change batch comment with {{.RecordSet}}, {{.AppID}}, {{.Timestamp}}, {{.HostName}}, {{.TaskCount}}, {{.AddedCount}}, {{.RemovedCount}}, {{.CycleNumber}} and {{.GoVersion}}")
+	fs.StringVar(&hostedZoneIDParam, "hosted-zone-id-ssm-param", "", "SSM Parameter Store parameter holding the hosted zone id, instead of hosted-zone-id")
+	fs.StringVar(&appIDRegex, "app-id-regex", "", "Regular expression of the ids of the Marathon apps to update, each with a record set named after the app id within record-set, overrides app-id")
+	fs.StringVar(&cfg.AppGroup, "app-group", "", "Marathon group whose apps are all updated, each with a record set named after the app within record-set, overrides app-id")
+	fs.BoolVar(&cfg.CreateHealthChecks, "create-health-checks", false, "Create a Route53 health check for the IP of every weighted record")
+	fs.Int64Var(&cfg.HealthCheckPort, "health-check-port", 80, "Port the health checks of create-health-checks connect to")
+	fs.StringVar(&cfg.HealthCheckPath, "health-check-path", "/", "Path the health checks of create-health-checks request")
+	fs.StringVar(&cfg.HealthCheckProtocol, "health-check-protocol", route53.HealthCheckTypeHttp, "Protocol of the health checks of create-health-checks: HTTP or HTTPS")
+	fs.Var(&healthCheckTags, "health-check-tags", "Tag of the health checks the updater creates, as key=value, can be repeated, e.g. for cost allocation")
+	fs.BoolVar(&cfg.StartupSync, "startup-sync", true, "Update records from the current state of the apps on startup instead of waiting for the first event")
+	fs.Var(&zoneMappings, "zone-record", "Repeatable zoneId:recordSet:types tuple of a record set pointing at app-id, e.g. Z1234:lb.example.com:weighted,enumerated, same as zone-mappings")
+	fs.Var(&zoneMappings, "zone-mappings", "Comma separated, or repeated, zoneId:recordSet:types tuples of record sets in other Route53 hosted zones pointing at app-id, e.g. Z1234:lb.example.com:weighted, overrides hosted-zone-id and record-set")
+	fs.Var(&labelSelectors, "label-selector", "Only update the apps of app-group or app-id-regex with this label, as key or key=value, can be repeated and all must match")
+	fs.Var(&filterLabels, "filter-label", "Only include the tasks of apps with this label, as key or key=value, can be repeated and all must match")
+	fs.StringVar(&cfg.PlanOutput, "plan-output", "", "File the Route53 change batches are appended to as JSON lines before they are submitted, - for stdout")
+	fs.StringVar(&cfg.AuditLog, "audit-log", "", "File the Route53 change batches are appended to as JSON lines with their change id once they are submitted")
+	fs.Int64Var(&cfg.AuditLogMaxSize, "audit-log-max-size", 100*1024*1024, "Size in bytes beyond which audit-log is rotated to audit-log.1, audit-log.2 and so on")
+	fs.StringVar(&cfg.RecordValue, "record-value", RECORD_VALUE_IP, "What records point at: ip for A records to the task IPs or host for CNAME records to the task hosts")
+	fs.StringVar(&cfg.RecordType, "record-type", RECORD_TYPE_A, "Type of the records of the task IPs: a for A records to the IPv4 addresses or aaaa-only for AAAA records to the IPv6 addresses in their place, e.g. in IPv6-only clusters")
+	fs.BoolVar(&cfg.UseMesosDNS, "use-mesos-dns", false, "Point CNAME records at the Mesos DNS names of the tasks instead of A records at their IPs, falling back to the IPs if a name doesn't resolve")
+	fs.DurationVar(&cfg.PollInterval, "poll-interval", 0, "Update records when there has been no update for this long, in case events were missed, 0 disables")
+	fs.DurationVar(&cfg.ForceResyncInterval, "force-resync-interval", 0, "Update records at this interval regardless of events, correcting records changed outside of the updater, 0 disables")
+	fs.StringVar(&cfg.FailoverSecondaryIP, "failover-secondary-ip", "", "Static IP of the secondary record of the failover-secondary record set type")
+	fs.StringVar(&cfg.ExcludeIPs, "exclude-ips", "", "Comma separated list of IPs and CIDR blocks that are never registered, defaults to $EXCLUDE_IPS which is re-read on every update")
+	fs.StringVar(&cfg.NotifyURL, "notify-url", "", "HTTP(S) endpoint a JSON summary of the added and removed IPs is POSTed to after every successful update")
+	fs.DurationVar(&cfg.NotifyTimeout, "notify-timeout", 5*time.Second, "Timeout of a request to notify-url")
+	fs.IntVar(&cfg.NotifyRetries, "notify-retries", 2, "Number of times a failed request to notify-url is retried")
+	fs.BoolVar(&cfg.PrivateZone, "private-zone", false, "Verify at startup that the hosted zones are private and associated with the VPC of this EC2 instance")
+	fs.BoolVar(&cfg.ScaleDownGuard, "scale-down-guard", false, "Keep the records of an app without running tasks instead of failing its update")
+	fs.DurationVar(&cfg.ScaleDownGuardTTL, "scale-down-guard-ttl", 0, "Delete the records of an app kept by scale-down-guard once it has had no running tasks for this long, 0 keeps them")
+	fs.DurationVar(&cfg.TaskWarmupDelay, "task-warmup-delay", 0, "Time a new task has to be running before it is added to DNS, e.g. while its app initializes")
+	fs.BoolVar(&cfg.NormalizeWeights, "normalize-weights", false, "Scale the weights of the weighted records of a record set so they add up to 1000")
+	fs.BoolVar(&cfg.WatchDeployments, "watch-deployments", false, "Update right away when a deployment of an app succeeds instead of debouncing, and warn when one fails")
+	fs.BoolVar(&cfg.EnablePprof, "enable-pprof", false, "Serve the Go pprof profiles at /debug/pprof/ on the admin port")
+	fs.StringVar(&cfg.UpdateSecret, "update-secret", "", "Shared secret required in the X-Update-Secret header of POST /update, which is disabled if empty")
+	fs.StringVar(&appIds, "app-ids", "", "Comma separated list of appId:record-set pairs to update, overrides app-id and record-set")
+	fs.StringVar(&configFile, "config", "", "Path to a YAML or TOML config file, keys are flag names and flags set on the command line take precedence")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "marathon-password" {
+			log.Printf("WARNING: marathon-password given on the command line is visible in the process list, prefer $MARATHON_PASSWORD")
+		}
+		if f.Name == "admin-password" {
+			log.Printf("WARNING: admin-password given on the command line is visible in the process list, prefer $ADMIN_PASSWORD")
+		}
+		if f.Name == "marathon-oauth-token" {
+			log.Printf("WARNING: marathon-oauth-token given on the command line is visible in the process list, prefer $MARATHON_OAUTH_TOKEN")
+		}
+	})
+
+	if configFile != "" {
+		if err := applyConfigFile(fs, configFile); err != nil {
+			return cfg, fmt.Errorf("Invalid config file: %v", err)
+		}
+		cfg.ConfigFile = configFile
+	}
+
+	cfg.MarathonHosts = []string{strings.TrimSuffix(cfg.MarathonHost, "/")}
+	if marathonHosts != "" {
+		cfg.MarathonHosts = nil
+		for _, host := range strings.Split(marathonHosts, ",") {
+			host = strings.TrimSuffix(strings.TrimSpace(host), "/")
+			if host == "" {
+				continue
+			}
+			if parsed, err := url.Parse(host); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return cfg, fmt.Errorf("marathon-hosts must be http or https URLs, got %q", host)
+			}
+			cfg.MarathonHosts = append(cfg.MarathonHosts, host)
+		}
+		if len(cfg.MarathonHosts) == 0 {
+			return cfg, fmt.Errorf("marathon-hosts must hold at least one URL, got %q", marathonHosts)
+		}
+		cfg.MarathonHost = cfg.MarathonHosts[0]
+	}
+
+	if cfg.MarathonUser == "" {
+		cfg.MarathonUser = os.Getenv("MARATHON_USER")
+	}
+	if cfg.MarathonPassword == "" {
+		cfg.MarathonPassword = os.Getenv("MARATHON_PASSWORD")
+	}
+	if cfg.MarathonOAuthToken == "" {
+		cfg.MarathonOAuthToken = os.Getenv("MARATHON_OAUTH_TOKEN")
+	}
+	if cfg.AdminPassword == "" {
+		cfg.AdminPassword = os.Getenv("ADMIN_PASSWORD")
+	}
+	if cfg.AdminUser != "" && cfg.AdminPassword == "" {
+		return cfg, errors.New("admin-user requires admin-password or $ADMIN_PASSWORD")
+	}
+	if cfg.HealthEndpointPublic && cfg.AdminUser == "" {
+		return cfg, errors.New("health-endpoint-public requires admin-user")
+	}
+	// Both are sent in the Authorization header
+	if cfg.MarathonOAuthToken != "" && cfg.MarathonUser != "" {
+		return cfg, errors.New("Only one of marathon-oauth-token and marathon-user can be given")
+	}
+
+	if hostedZoneIDParam != "" {
+		if cfg.HostedZoneID != "" {
+			return cfg, errors.New("Only one of hosted-zone-id and hosted-zone-id-ssm-param can be given")
+		}
+		hostedZoneID, err := ssmParameter(hostedZoneIDParam)
+		if err != nil {
+			return cfg, fmt.Errorf("Unable to read hosted zone id from SSM parameter %s: %v", hostedZoneIDParam, err)
+		}
+		cfg.HostedZoneID = hostedZoneID
+	}
+
+	if len(zoneMappings) > 0 {
+		if appIds != "" || cfg.AppGroup != "" {
+			return cfg, errors.New("zone-mappings can't be combined with app-ids or app-group")
+		}
+		if cfg.DNSProvider != ROUTE53 {
+			return cfg, errors.New("zone-mappings is only supported by the route53 dns-provider")
+		}
+		mappings, err := parseZoneMappings(zoneMappings.String(), appId)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.AppRecordSets = mappings
+		// The first zone identifies the updater, e.g. in the leader lock key
+		if cfg.HostedZoneID == "" {
+			cfg.HostedZoneID = mappings[0].HostedZoneID
+		}
+	}
+
+	// The managed zone identifies the zone of the google dns-provider, e.g. in the leader lock key
+	if cfg.DNSProvider == GOOGLE && cfg.HostedZoneID == "" {
+		cfg.HostedZoneID = cfg.GCPManagedZone
+	}
+	if cfg.DNSProvider == AZURE && cfg.HostedZoneID == "" {
+		cfg.HostedZoneID = cfg.AzureDNSZoneName
+	}
+	// Consul has no zones, the datacenter takes their place
+	if cfg.DNSProvider == CONSUL && cfg.HostedZoneID == "" {
+		cfg.HostedZoneID = strings.TrimSuffix("consul-"+cfg.ConsulDC, "-")
+	}
+	if cfg.HostedZoneID == "" {
+		return cfg, errors.New("Hosted zone id is required")
+	}
+
+	if appIDRegex != "" {
+		if appIds != "" || cfg.AppGroup != "" || len(zoneMappings) > 0 {
+			return cfg, errors.New("app-id-regex can't be combined with app-ids, app-group or zone-mappings")
+		}
+		pattern, err := regexp.Compile(appIDRegex)
+		if err != nil {
+			return cfg, fmt.Errorf("Invalid app-id-regex: %v", err)
+		}
+		// The apps are discovered on every update and get record sets within record-set like the
+		// apps of app-group
+		cfg.AppIDRegex = pattern
+		cfg.AppGroupRecordSet = recordSetName
+	} else if cfg.AppGroup != "" {
+		if appIds != "" {
+			return cfg, errors.New("Only one of app-group and app-ids can be given")
+		}
+		// The apps of the group are looked up on every update
+		cfg.AppGroup = "/" + strings.Trim(cfg.AppGroup, "/")
+		cfg.AppGroupRecordSet = recordSetName
+	} else if appIds != "" {
+		for _, pair := range strings.Split(appIds, ",") {
+			parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return cfg, fmt.Errorf("Invalid app-ids entry %q, expected appId:record-set", pair)
+			}
+			cfg.AppRecordSets = append(cfg.AppRecordSets, AppRecordSet{AppID: parts[0], RecordSet: parts[1]})
+		}
+	} else if len(zoneMappings) == 0 {
+		cfg.AppRecordSets = []AppRecordSet{{AppID: appId, RecordSet: recordSetName}}
+	}
+
+	if strings.Trim(strings.ToLower(cfg.RecordSetPrefix), "abcdefghijklmnopqrstuvwxyz0123456789-.") != "" {
+		return cfg, fmt.Errorf("record-set-prefix may only contain letters, digits, - and ., got %q", cfg.RecordSetPrefix)
+	}
+	if strings.Contains(cfg.RecordSetPrefix, ".") {
+		log.Printf("WARNING: record-set-prefix %q contains a ., enumerated records are numbered after its first label", cfg.RecordSetPrefix)
+	}
+
+	// The suffix is part of the first label, which the enumerated records are numbered after
+	if strings.Trim(strings.ToLower(cfg.RecordSetSuffix), "abcdefghijklmnopqrstuvwxyz0123456789-") != "" {
+		return cfg, fmt.Errorf("record-set-suffix may only contain letters, digits and -, got %q", cfg.RecordSetSuffix)
+	}
+
+	for idx := range cfg.AppRecordSets {
+		if !strings.HasPrefix(cfg.AppRecordSets[idx].AppID, "/") {
+			cfg.AppRecordSets[idx].AppID = "/" + cfg.AppRecordSets[idx].AppID
+		}
+		cfg.AppRecordSets[idx].RecordSet = DecoratedRecordSet(cfg, cfg.AppRecordSets[idx].RecordSet)
+		if err := ValidateRecordSetName(cfg.AppRecordSets[idx].RecordSet); err != nil {
+			return cfg, err
+		}
+		if cfg.RecordSetPrefix != "" || cfg.RecordSetSuffix != "" {
+			log.Printf("Using record set %s for appId: %s", cfg.AppRecordSets[idx].RecordSet, cfg.AppRecordSets[idx].AppID)
+		}
+	}
+
+	for name, ttl := range map[string]int64{"weighted-ttl": cfg.WeightedTTL, "enumerated-ttl": cfg.EnumeratedTTL} {
+		if err := validateTTL(name, ttl); err != nil {
+			return cfg, err
+		}
+	}
+
+	if cfg.AdaptiveTTL {
+		if cfg.MinTTL < time.Second || cfg.MinTTL%time.Second != 0 || cfg.MaxTTL%time.Second != 0 {
+			return cfg, fmt.Errorf("min-ttl and max-ttl must be whole seconds of at least 1s, got %v and %v", cfg.MinTTL, cfg.MaxTTL)
+		}
+		if cfg.MaxTTL < cfg.MinTTL {
+			return cfg, fmt.Errorf("max-ttl must not be less than min-ttl, got %v and %v", cfg.MaxTTL, cfg.MinTTL)
+		}
+		if err := validateTTL("max-ttl", int64(cfg.MaxTTL/time.Second)); err != nil {
+			return cfg, err
+		}
+		if cfg.StableCycles < 1 {
+			return cfg, fmt.Errorf("stable-cycles must be at least 1, got %d", cfg.StableCycles)
+		}
+	}
+
+	if cfg.EnumeratedStartIndex < 0 {
+		return cfg, fmt.Errorf("enumerated-start-index must not be negative, got %d", cfg.EnumeratedStartIndex)
+	}
+	// A prefix of only digits would make the AAAA records look like enumerated A records
+	if cfg.AAAAEnumeratedPrefix == "" || strings.Contains(cfg.AAAAEnumeratedPrefix, ".") ||
+		strings.Trim(cfg.AAAAEnumeratedPrefix, "0123456789") == "" {
+		return cfg, fmt.Errorf("aaaa-enumerated-prefix must be a label part with at least one non-digit, got %q", cfg.AAAAEnumeratedPrefix)
+	}
+
+	for _, prefix := range strings.Split(managedIdentifierPrefixes, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			cfg.ManagedIdentifierPrefixes = append(cfg.ManagedIdentifierPrefixes, prefix)
+		}
+	}
+	if len(cfg.ManagedIdentifierPrefixes) == 0 {
+		return cfg, errors.New("managed-identifier-prefix must not be empty")
+	}
+	for _, identifier := range defaultManagedIdentifierPrefixes {
+		if !IsManagedSetIdentifier(cfg, identifier) {
+			log.Printf("WARNING: managed-identifier-prefix doesn't match %q, stale record sets with such set identifiers are never deleted", identifier)
+		}
+	}
+
+	cfg.RecordSetTypes = map[string]bool{}
+	for _, recordSetType := range strings.Split(recordSetType, ",") {
+		cfg.RecordSetTypes[strings.ToLower(strings.TrimSpace(recordSetType))] = true
+	}
+	// The types of zone-mappings replace record-set-type, the validation below applies to all of them
+	if len(zoneMappings) > 0 {
+		cfg.RecordSetTypes = map[string]bool{}
+		for _, target := range cfg.AppRecordSets {
+			for recordSetType := range target.RecordSetTypes {
+				cfg.RecordSetTypes[recordSetType] = true
+			}
+		}
+	}
+
+	switch cfg.DNSProvider {
+	case ROUTE53:
+	case CLOUDFLARE:
+		if cfg.CloudflareAPIToken == "" {
+			cfg.CloudflareAPIToken = os.Getenv("CLOUDFLARE_API_TOKEN")
+		}
+	case GOOGLE:
+		if cfg.GCPManagedZone == "" {
+			cfg.GCPManagedZone = cfg.HostedZoneID
+		}
+		if cfg.GCPProject == "" {
+			return cfg, errors.New("gcp-project is required by the google dns-provider")
+		}
+	case AZURE:
+		if cfg.AzureDNSZoneName == "" {
+			cfg.AzureDNSZoneName = cfg.HostedZoneID
+		}
+		if cfg.AzureSubscriptionID == "" || cfg.AzureResourceGroup == "" {
+			return cfg, errors.New("azure-subscription-id and azure-resource-group are required by the azure dns-provider")
+		}
+	case CONSUL:
+		// Service instances can only resolve to the IPs of tasks
+		if cfg.CNAMETarget != "" || cfg.RecordValue == RECORD_VALUE_HOST || cfg.UseMesosDNS {
+			return cfg, errors.New("The consul dns-provider can't be combined with cname-target, record-value host or use-mesos-dns")
+		}
+	default:
+		return cfg, fmt.Errorf("Unknown dns-provider %q", cfg.DNSProvider)
+	}
+
+	if cfg.LogFormat != LOG_FORMAT_TEXT && cfg.LogFormat != LOG_FORMAT_JSON {
+		return cfg, fmt.Errorf("Unknown log-format %q", cfg.LogFormat)
+	}
+	if _, ok := LogLevels[cfg.LogLevel]; !ok || cfg.LogLevel == "fatal" {
+		return cfg, fmt.Errorf("Unknown log-level %q", cfg.LogLevel)
+	}
+
+	if debounceMs < 0 {
+		return cfg, fmt.Errorf("debounce-ms must not be negative, got %d", debounceMs)
+	}
+	cfg.Debounce = time.Duration(debounceMs) * time.Millisecond
+
+	if cfg.StartupRetryMaxWait < 0 {
+		return cfg, fmt.Errorf("startup-retry-max-wait must not be negative, got %v", cfg.StartupRetryMaxWait)
+	}
+	if cfg.SSEReconnectDelay <= 0 || cfg.SSEMaxReconnectDelay < cfg.SSEReconnectDelay {
+		return cfg, fmt.Errorf("sse-reconnect-delay must be greater than 0 and at most sse-max-reconnect-delay")
+	}
+
+	commentTemplate, err := template.New("record-set-comment").Parse(recordSetComment)
+	if err == nil {
+		// Catches references to unknown variables
+		err = commentTemplate.Execute(ioutil.Discard, ChangeCommentData{})
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("Invalid record-set-comment: %v", err)
+	}
+	cfg.RecordSetComment = commentTemplate
+
+	cfg.FilterLabels, err = parseLabelFilters("filter-label", filterLabels)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.HealthCheckTags = map[string]string{}
+	for _, tag := range strings.Split(healthCheckTags.String(), ",") {
+		if tag == "" {
+			continue
+		}
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return cfg, fmt.Errorf("Invalid health-check-tags %q, expected key=value", tag)
+		}
+		// The updater finds its health checks by these tags
+		if parts[0] == HEALTH_CHECK_MANAGED_BY_TAG || parts[0] == HEALTH_CHECK_RECORD_SET_TAG {
+			return cfg, fmt.Errorf("health-check-tags can't set the %s tag, the updater sets it itself", parts[0])
+		}
+		cfg.HealthCheckTags[parts[0]] = parts[1]
+	}
+	if len(cfg.HealthCheckTags) > MAX_HEALTH_CHECK_TAGS {
+		return cfg, fmt.Errorf("health-check-tags can set at most %d tags, got %d", MAX_HEALTH_CHECK_TAGS, len(cfg.HealthCheckTags))
+	}
+	cfg.LabelSelectors, err = parseLabelFilters("label-selector", labelSelectors)
+	if err != nil {
+		return cfg, err
+	}
+	// The apps of the other modes are given explicitly
+	if len(cfg.LabelSelectors) > 0 && cfg.AppGroup == "" && cfg.AppIDRegex == nil {
+		return cfg, errors.New("label-selector requires app-group or app-id-regex")
+	}
+
+	if cfg.PollInterval < 0 {
+		return cfg, fmt.Errorf("poll-interval must not be negative, got %v", cfg.PollInterval)
+	}
+	if cfg.ForceResyncInterval < 0 {
+		return cfg, fmt.Errorf("force-resync-interval must not be negative, got %v", cfg.ForceResyncInterval)
+	}
+
+	if _, err := ExcludedNetworks(cfg); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Route53EndpointURL != "" {
+		if parsed, err := url.Parse(cfg.Route53EndpointURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return cfg, fmt.Errorf("route53-endpoint-url must be an http or https URL, got %q", cfg.Route53EndpointURL)
+		}
+		if cfg.DNSProvider != ROUTE53 {
+			return cfg, fmt.Errorf("route53-endpoint-url requires the %s dns-provider", ROUTE53)
+		}
+	}
+
+	if cfg.AuditLog != "" {
+		if cfg.AuditLogMaxSize < 1 {
+			return cfg, fmt.Errorf("audit-log-max-size must be at least 1, got %d", cfg.AuditLogMaxSize)
+		}
+		if cfg.DNSProvider != ROUTE53 {
+			return cfg, fmt.Errorf("audit-log requires the %s dns-provider", ROUTE53)
+		}
+	}
+
+	if cfg.NotifyURL != "" {
+		if parsed, err := url.Parse(cfg.NotifyURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return cfg, fmt.Errorf("notify-url must be an http or https URL, got %q", cfg.NotifyURL)
+		}
+		if cfg.NotifyTimeout <= 0 || cfg.NotifyRetries < 0 {
+			return cfg, errors.New("notify-timeout must be greater than 0 and notify-retries must not be negative")
+		}
+	}
+
+	if cfg.MinHealthyTasks < 1 {
+		return cfg, fmt.Errorf("min-healthy-tasks must be at least 1, got %d", cfg.MinHealthyTasks)
+	}
+
+	if cfg.MaxIPs < 0 {
+		return cfg, fmt.Errorf("max-ips must not be negative, got %d", cfg.MaxIPs)
+	}
+
+	if cfg.ScaleDownGuardTTL < 0 {
+		return cfg, fmt.Errorf("scale-down-guard-ttl must not be negative, got %v", cfg.ScaleDownGuardTTL)
+	}
+	if cfg.ScaleDownGuardTTL > 0 && !cfg.ScaleDownGuard {
+		return cfg, errors.New("scale-down-guard-ttl requires scale-down-guard")
+	}
+	if cfg.TaskWarmupDelay < 0 {
+		return cfg, fmt.Errorf("task-warmup-delay must not be negative, got %v", cfg.TaskWarmupDelay)
+	}
+	if cfg.UpdateTimeout <= 0 {
+		return cfg, fmt.Errorf("update-timeout must be greater than 0, got %v", cfg.UpdateTimeout)
+	}
+	if cfg.Route53WaitTimeout <= 0 {
+		return cfg, fmt.Errorf("route53-wait-timeout must be greater than 0, got %v", cfg.Route53WaitTimeout)
+	}
+
+	if cfg.Route53Concurrency < 1 {
+		return cfg, fmt.Errorf("route53-concurrency must be at least 1, got %d", cfg.Route53Concurrency)
+	}
+
+	if cfg.MaxEventBatch < 1 {
+		return cfg, fmt.Errorf("max-event-batch must be at least 1, got %d", cfg.MaxEventBatch)
+	}
+
+	if cfg.ListConcurrency < 1 {
+		return cfg, fmt.Errorf("list-concurrency must be at least 1, got %d", cfg.ListConcurrency)
+	}
+
+	if cfg.Route53RPS <= 0 {
+		return cfg, fmt.Errorf("route53-rps must be greater than 0, got %v", cfg.Route53RPS)
+	}
+
+	if cfg.WeightedBy != WEIGHTED_BY_FLAT && cfg.WeightedBy != WEIGHTED_BY_CPU {
+		return cfg, fmt.Errorf("Unknown weighted-by %q", cfg.WeightedBy)
+	}
+
+	if cfg.CNAMETarget != "" {
+		for recordSetType := range cfg.RecordSetTypes {
+			if recordSetType != ENUMERATED {
+				return cfg, fmt.Errorf("cname-target creates enumerated CNAME records and can't be combined with the %s record set type", recordSetType)
+			}
+		}
+	}
+
+	if (cfg.AliasTarget == "") != (cfg.AliasHostedZone == "") {
+		return cfg, errors.New("alias-target and alias-hosted-zone must be given together")
+	}
+	if cfg.AliasTarget != "" && cfg.DNSProvider != ROUTE53 {
+		return cfg, errors.New("Alias records are only supported by the route53 dns-provider")
+	}
+
+	if cfg.PrivateZone && cfg.DNSProvider != ROUTE53 {
+		return cfg, errors.New("private-zone is only supported by the route53 dns-provider")
+	}
+
+	if cfg.CreateTXTRecords && cfg.DNSProvider != ROUTE53 {
+		return cfg, errors.New("create-txt-records is only supported by the route53 dns-provider")
+	}
+
+	if cfg.RecordSetTypes[FAILOVER_PRIMARY] || cfg.RecordSetTypes[FAILOVER_SECONDARY] {
+		if cfg.DNSProvider != ROUTE53 {
+			return cfg, errors.New("The failover record set types are only supported by the route53 dns-provider")
+		}
+		// Route53 doesn't allow different routing policies for record sets of the same name and type
+		if cfg.RecordSetTypes[WEIGHTED] || cfg.AliasTarget != "" || cfg.RecordValue == RECORD_VALUE_HOST || cfg.UseMesosDNS {
+			return cfg, errors.New("The failover record set types can't be combined with weighted records, alias-target, record-value host or use-mesos-dns")
+		}
+	}
+	if cfg.RecordSetTypes[LATENCY] {
+		if cfg.DNSProvider != ROUTE53 {
+			return cfg, errors.New("The latency record set type is only supported by the route53 dns-provider")
+		}
+		// Route53 doesn't allow different routing policies for record sets of the same name and type
+		if cfg.RecordSetTypes[WEIGHTED] || cfg.RecordSetTypes[FAILOVER_PRIMARY] || cfg.RecordSetTypes[FAILOVER_SECONDARY] || cfg.AliasTarget != "" {
+			return cfg, errors.New("The latency record set type can't be combined with weighted or failover records or alias-target")
+		}
+		if cfg.AWSRegion == "" {
+			cfg.AWSRegion = os.Getenv("AWS_REGION")
+		}
+		// The tasks of a Marathon cluster run in a single region, slave ids don't tell which
+		if _, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), cfg.AWSRegion); !ok {
+			return cfg, fmt.Errorf("The latency record set type requires aws-region to be an AWS region, got %q", cfg.AWSRegion)
+		}
+	}
+	if cfg.RecordSetTypes[GEO] {
+		if cfg.DNSProvider != ROUTE53 {
+			return cfg, errors.New("The geo record set type is only supported by the route53 dns-provider")
+		}
+		// Route53 doesn't allow different routing policies for record sets of the same name and type
+		if cfg.RecordSetTypes[WEIGHTED] || cfg.RecordSetTypes[LATENCY] || cfg.RecordSetTypes[FAILOVER_PRIMARY] ||
+			cfg.RecordSetTypes[FAILOVER_SECONDARY] || cfg.AliasTarget != "" {
+			return cfg, errors.New("The geo record set type can't be combined with weighted, latency or failover records or alias-target")
+		}
+		cfg.GeoContinentCode = strings.ToUpper(cfg.GeoContinentCode)
+		cfg.GeoCountryCode = strings.ToUpper(cfg.GeoCountryCode)
+		if (cfg.GeoContinentCode == "") == (cfg.GeoCountryCode == "") {
+			return cfg, errors.New("The geo record set type requires one of geo-continent-code and geo-country-code")
+		}
+		if cfg.GeoContinentCode != "" && !geoContinentCodes[cfg.GeoContinentCode] {
+			return cfg, fmt.Errorf("Invalid geo-continent-code %q, expected one of AF, AN, AS, EU, NA, OC and SA", cfg.GeoContinentCode)
+		}
+		if cfg.GeoCountryCode != "" && (len(cfg.GeoCountryCode) != 2 || strings.Trim(cfg.GeoCountryCode, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "") {
+			return cfg, fmt.Errorf("Invalid geo-country-code %q, expected an ISO 3166-1 alpha-2 code", cfg.GeoCountryCode)
+		}
+	}
+	if cfg.RecordSetTypes[MULTIVALUE] {
+		if cfg.DNSProvider != ROUTE53 {
+			return cfg, errors.New("The multivalue record set type is only supported by the route53 dns-provider")
+		}
+		// Route53 doesn't allow different routing policies for record sets of the same name and type
+		if cfg.RecordSetTypes[WEIGHTED] || cfg.RecordSetTypes[LATENCY] || cfg.RecordSetTypes[GEO] || cfg.RecordSetTypes[FAILOVER_PRIMARY] ||
+			cfg.RecordSetTypes[FAILOVER_SECONDARY] || cfg.AliasTarget != "" {
+			return cfg, errors.New("The multivalue record set type can't be combined with weighted, latency, geo or failover records or alias-target")
+		}
+		if cfg.RecordValue == RECORD_VALUE_HOST || cfg.UseMesosDNS {
+			return cfg, errors.New("The multivalue record set type can't be combined with record-value host or use-mesos-dns, Route53 has no multivalue CNAME records")
+		}
+	}
+	if cfg.RecordSetTypes[FAILOVER_SECONDARY] {
+		if cfg.RecordType == RECORD_TYPE_AAAA_ONLY {
+			if ip := net.ParseIP(cfg.FailoverSecondaryIP); ip == nil || ip.To4() != nil {
+				return cfg, fmt.Errorf("failover-secondary with record-type aaaa-only requires failover-secondary-ip to be an IPv6 address, got %q", cfg.FailoverSecondaryIP)
+			}
+		} else if ip := net.ParseIP(cfg.FailoverSecondaryIP); ip == nil || ip.To4() == nil {
+			return cfg, fmt.Errorf("failover-secondary requires failover-secondary-ip to be an IPv4 address, got %q", cfg.FailoverSecondaryIP)
+		}
+	}
+
+	if len(cfg.HealthCheckTags) > 0 && !cfg.CreateHealthChecks && !cfg.RecordSetTypes[FAILOVER_PRIMARY] {
+		return cfg, errors.New("health-check-tags requires create-health-checks or the failover-primary record set type")
+	}
+	// The primary failover record is associated with health checks of the task IPs
+	if cfg.CreateHealthChecks || cfg.RecordSetTypes[FAILOVER_PRIMARY] {
+		if cfg.DNSProvider != ROUTE53 {
+			return cfg, errors.New("create-health-checks is only supported by the route53 dns-provider")
+		}
+		if cfg.AliasTarget != "" {
+			return cfg, errors.New("create-health-checks can't be combined with alias-target, alias records evaluate the health of their target")
+		}
+		if cfg.HealthCheckProtocol != route53.HealthCheckTypeHttp && cfg.HealthCheckProtocol != route53.HealthCheckTypeHttps {
+			return cfg, fmt.Errorf("Unknown health-check-protocol %q", cfg.HealthCheckProtocol)
+		}
+		if cfg.HealthCheckPort < 1 || cfg.HealthCheckPort > 65535 {
+			return cfg, fmt.Errorf("Invalid health-check-port %d", cfg.HealthCheckPort)
+		}
+	}
+
+	switch cfg.RecordValue {
+	case RECORD_VALUE_IP:
+	case RECORD_VALUE_HOST:
+		if cfg.UseMesosDNS {
+			return cfg, errors.New("use-mesos-dns can't be combined with record-value host")
+		}
+	default:
+		return cfg, fmt.Errorf("Unknown record-value %q", cfg.RecordValue)
+	}
+	switch cfg.RecordType {
+	case RECORD_TYPE_A:
+	case RECORD_TYPE_AAAA_ONLY:
+		if cfg.RecordValue == RECORD_VALUE_HOST || cfg.UseMesosDNS {
+			return cfg, errors.New("record-type aaaa-only can't be combined with record-value host or use-mesos-dns, which create CNAME records")
+		}
+		// The IPv6 addresses already take the place of the IPv4 addresses
+		if cfg.RecordSetTypes[WEIGHTED_IPV6] || cfg.RecordSetTypes[ENUMERATED_IPV6] {
+			return cfg, errors.New("record-type aaaa-only creates AAAA records with the weighted and enumerated record set types and can't be combined with the ipv6 ones")
+		}
+	default:
+		return cfg, fmt.Errorf("Unknown record-type %q", cfg.RecordType)
+	}
+	// Both point the records at names of the tasks, which can only be the value of CNAME records
+	if cfg.RecordValue == RECORD_VALUE_HOST || cfg.UseMesosDNS {
+		if cfg.CNAMETarget != "" || cfg.AliasTarget != "" || cfg.CreateHealthChecks {
+			return cfg, errors.New("record-value host and use-mesos-dns can't be combined with cname-target, alias-target or create-health-checks")
+		}
+		if cfg.RecordSetTypes[WEIGHTED_IPV6] || cfg.RecordSetTypes[ENUMERATED_IPV6] {
+			return cfg, errors.New("record-value host and use-mesos-dns create CNAME records and can't be combined with the ipv6 record set types")
+		}
+		if cfg.RecordSetTypes[WEIGHTED] && cfg.DNSProvider != ROUTE53 {
+			return cfg, errors.New("Weighted CNAME records of record-value host and use-mesos-dns are only supported by the route53 dns-provider")
+		}
+	}
+
+	if cfg.RecordSetTypes[SRV] && cfg.DNSProvider != ROUTE53 {
+		return cfg, errors.New("The srv record set type is only supported by the route53 dns-provider")
+	}
+
+	return cfg, nil
+}
+
+// readConfigFile parses a YAML or TOML config file, picked by its extension, into flag values keyed
+// by flag name. Every key must be the name of a flag.
+func readConfigFile(fs *flag.FlagSet, path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("%s: unsupported config file extension, expected .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	values := map[string]string{}
+	for key, value := range raw {
+		if key == "config" || fs.Lookup(key) == nil {
+			return nil, fmt.Errorf("%s: unknown field %q", path, key)
+		}
+
+		switch v := value.(type) {
+		case []interface{}:
+			// Lists are accepted for the comma separated flags, e.g. record-set-type
+			items := make([]string, len(v))
+			for idx, item := range v {
+				items[idx] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		case map[string]interface{}:
+			return nil, fmt.Errorf("%s: field %q must be a scalar or a list", path, key)
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+
+	return values, nil
+}
+
+// applyConfigFile sets every flag of fs from the config file at path that wasn't set on the command line
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	values, err := readConfigFile(fs, path)
+	if err != nil {
+		return err
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	// Apply in a stable order so the first error reported is deterministic
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if explicit[key] {
+			continue
+		}
+		if err := fs.Set(key, values[key]); err != nil {
+			return fmt.Errorf("%s: invalid value %q for field %q: %v", path, values[key], key, err)
+		}
+	}
+
+	return nil
+}
+
+// ChangeCommentData holds the variables of the record-set-comment template
+type ChangeCommentData struct {
+	RecordSet    string
+	AppID        string
+	Timestamp    string
+	HostName     string
+	TaskCount    int
+	AddedCount   int
+	RemovedCount int
+	CycleNumber  int64
+	GoVersion    string
+}
+
+// IsManagedSetIdentifier reports whether identifier may have been given by the updater, i.e. whether
+// it starts with one of managed-identifier-prefix. Record sets with other set identifiers are left
+// alone.
+func IsManagedSetIdentifier(cfg Config, identifier string) bool {
+	for _, prefix := range cfg.ManagedIdentifierPrefixes {
+		if strings.HasPrefix(identifier, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExcludedNetworks returns the networks of exclude-ips. Without the flag $EXCLUDE_IPS is read on
+// every call, so the IPs of nodes going into maintenance can be excluded without a restart.
+func ExcludedNetworks(cfg Config) ([]*net.IPNet, error) {
+	value := cfg.ExcludeIPs
+	if value == "" {
+		value = os.Getenv("EXCLUDE_IPS")
+	}
+	networks, err := parseExcludeIps(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid exclude-ips: %v", err)
+	}
+	return networks, nil
+}
+
+// parseExcludeIps parses a comma separated list of CIDR blocks and exact IPs
+func parseExcludeIps(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is neither an IP nor a CIDR block", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an IP nor a CIDR block", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// validateTTL checks that ttl is within the range accepted by Route53
+func validateTTL(name string, ttl int64) error {
+	if ttl < 1 || ttl > math.MaxInt32 {
+		return fmt.Errorf("%s must be between 1 and %d, got %d", name, math.MaxInt32, ttl)
+	}
+	return nil
+}
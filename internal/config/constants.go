@@ -0,0 +1,65 @@
+package config
+
+const (
+	ROUTE53    = "route53"
+	CLOUDFLARE = "cloudflare"
+	GOOGLE     = "google"
+	AZURE      = "azure"
+	CONSUL     = "consul"
+)
+
+const (
+	WEIGHTED        = "weighted"
+	ENUMERATED      = "enumerated"
+	WEIGHTED_IPV6   = "weighted-ipv6"
+	ENUMERATED_IPV6 = "enumerated-ipv6"
+	SRV             = "srv"
+	LATENCY         = "latency"
+	GEO             = "geo"
+	MULTIVALUE      = "multivalue"
+
+	FAILOVER_PRIMARY   = "failover-primary"
+	FAILOVER_SECONDARY = "failover-secondary"
+)
+
+const (
+	WEIGHTED_BY_FLAT = "flat"
+	WEIGHTED_BY_CPU  = "cpu"
+
+	RECORD_VALUE_IP   = "ip"
+	RECORD_VALUE_HOST = "host"
+
+	RECORD_TYPE_A         = "a"
+	RECORD_TYPE_AAAA_ONLY = "aaaa-only"
+
+	// A DNS name is at most 253 characters long and each of its labels at most 63
+	MAX_NAME_LENGTH  = 253
+	MAX_LABEL_LENGTH = 63
+)
+
+const (
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+)
+
+// LogLevels orders the levels of log messages by severity
+var LogLevels = map[string]int{
+	"debug": 0,
+	"info":  1,
+	"warn":  2,
+	"error": 3,
+	"fatal": 4,
+}
+
+const (
+	HEALTH_CHECK_MANAGED_BY_TAG = "managed-by"
+	HEALTH_CHECK_MANAGED_BY     = "marathon-dns-updater"
+	HEALTH_CHECK_RECORD_SET_TAG = "record-set"
+
+	// A health check can have at most 10 tags, two of which identify it
+	MAX_HEALTH_CHECK_TAGS = 8
+)
+
+// defaultManagedIdentifierPrefixes are the prefixes of the set identifiers of the record sets the
+// updater creates, the default of managed-identifier-prefix
+var defaultManagedIdentifierPrefixes = []string{"weighted-", "latency-", "multivalue-", "geo-", FAILOVER_PRIMARY, FAILOVER_SECONDARY}
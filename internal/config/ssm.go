@@ -0,0 +1,43 @@
+package config
+
+import (
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+const (
+	SSM_MAX_ATTEMPTS = 3
+	SSM_RETRY_DELAY  = 1 * time.Second
+)
+
+// ssmParameter reads the value of an SSM Parameter Store parameter, decrypting SecureString
+// parameters, using the ambient credentials
+func ssmParameter(name string) (string, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return "", err
+	}
+	client := ssm.New(sess)
+
+	var output *ssm.GetParameterOutput
+	for attempt := 1; ; attempt++ {
+		output, err = client.GetParameter(&ssm.GetParameterInput{
+			Name:           aws.String(name),
+			WithDecryption: aws.Bool(true),
+		})
+		if err == nil || attempt == SSM_MAX_ATTEMPTS {
+			break
+		}
+		log.Printf("WARNING: Unable to read SSM parameter %s, retrying in %v: %v", name, SSM_RETRY_DELAY, err)
+		time.Sleep(SSM_RETRY_DELAY)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(output.Parameter.Value), nil
+}
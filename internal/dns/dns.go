@@ -0,0 +1,93 @@
+// Package dns defines the records managed through a DNSProvider and the contract of the providers
+package dns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// DNSRecord is a single value record as managed through a DNSProvider. SetIdentifier and Weight are
+// only set for weighted records and are ignored by providers without weighted routing.
+type DNSRecord struct {
+	Name          string
+	Type          string
+	Value         string
+	TTL           int64
+	SetIdentifier string
+	Weight        int64
+}
+
+// ManagedRecordTypes are the types of the records managed through a DNSProvider
+var ManagedRecordTypes = []string{route53.RRTypeA, route53.RRTypeAaaa, route53.RRTypeCname}
+
+// DNSProvider is the contract every DNS backend implements
+type DNSProvider interface {
+	// ListRecords returns the records of the ManagedRecordTypes named recordSet or one of its
+	// enumerated names, those of AAAA records with aaaaPrefix
+	ListRecords(recordSet string, aaaaPrefix string) ([]DNSRecord, error)
+	// UpsertRecord creates the record or updates it in place if it already exists
+	UpsertRecord(record DNSRecord) error
+	// DeleteRecord removes the record, it is not an error if the record doesn't exist
+	DeleteRecord(record DNSRecord) error
+}
+
+func (r DNSRecord) String() string {
+	return fmt.Sprintf("%s %d %s %s", r.Name, r.TTL, r.Type, r.Value)
+}
+
+// Key identifies a record by name, type and value, which is unique for the records we manage
+func (r DNSRecord) Key() string {
+	return strings.Join([]string{strings.ToLower(strings.TrimSuffix(r.Name, ".")), r.Type, r.Value}, " ")
+}
+
+func IsManagedRecordType(recordType string) bool {
+	for _, managedType := range ManagedRecordTypes {
+		if recordType == managedType {
+			return true
+		}
+	}
+	return false
+}
+
+// IsManagedRecordName reports whether name is recordSet itself or one of its enumerated names, those
+// of AAAA records set apart by aaaaPrefix (e.g. marathon-lb-1.example.com or
+// marathon-lb-ipv6-1.example.com for marathon-lb.example.com with aaaa-enumerated-prefix ipv6)
+func IsManagedRecordName(recordSet string, name string, aaaaPrefix string) bool {
+	name = strings.TrimSuffix(name, ".")
+	recordSet = strings.TrimSuffix(recordSet, ".")
+	if name == recordSet {
+		return true
+	}
+
+	parts := strings.SplitN(recordSet, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	prefix, suffix := parts[0]+"-", "."+parts[1]
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return false
+	}
+	idx := strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix)
+	idx = strings.TrimPrefix(idx, aaaaPrefix+"-")
+	return idx != "" && strings.Trim(idx, "0123456789") == ""
+}
+
+// SameStrings reports whether a and b hold the same strings, regardless of order
+func SameStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := map[string]int{}
+	for _, s := range a {
+		counts[s]++
+	}
+	for _, s := range b {
+		if counts[s] == 0 {
+			return false
+		}
+		counts[s]--
+	}
+	return true
+}
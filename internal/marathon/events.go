@@ -0,0 +1,94 @@
+package marathon
+
+import (
+	"encoding/json"
+	"time"
+
+	marathon "github.com/gambol99/go-marathon"
+)
+
+const (
+	StatusUpdateEvent      = "status_update_event"
+	DeploymentSuccessEvent = "deployment_success"
+	DeploymentFailedEvent  = "deployment_failed"
+)
+
+// This package is intentionally left incomplete. It can be extended with an exhaustive list in the future
+// if more complicated strategies are implemented or if there is a more generic use case for typed
+// Marathon events
+
+type StatusUpdate struct {
+	EventType   string    `json:"eventType"`
+	Timestamp   time.Time `json:"timestamp"`
+	SlaveID     string    `json:"slaveId"`
+	TaskID      string    `json:"taskId"`
+	TaskStatus  string    `json:"taskStatus"`
+	Message     string    `json:"message"`
+	AppID       string    `json:"appId"`
+	Host        string    `json:"host"`
+	IPAddresses []struct {
+		IPAddress string `json:"ipAddress"`
+		Protocol  string `json:"protocol"`
+	} `json:"ipAddresses"`
+	Ports   []int     `json:"ports"`
+	Version time.Time `json:"version"`
+}
+
+// DeploymentEvent is a deployment_success or deployment_failed event. go-marathon leaves the plan
+// out of some of its deployment event types, so both are decoded into this type.
+type DeploymentEvent struct {
+	ID        string                   `json:"id"`
+	EventType string                   `json:"eventType"`
+	Timestamp string                   `json:"timestamp"`
+	Plan      *marathon.DeploymentPlan `json:"plan"`
+}
+
+// EventAppIds returns the ids of the apps an event from the go-marathon event bus is about
+func EventAppIds(event *marathon.Event) []string {
+	switch e := event.Event.(type) {
+	case *marathon.EventStatusUpdate:
+		return []string{e.AppID}
+	case *DeploymentEvent:
+		return deploymentAppIds(e.Plan)
+	}
+	return nil
+}
+
+// deploymentAppIds returns the ids of the apps touched by the steps of a deployment plan
+func deploymentAppIds(plan *marathon.DeploymentPlan) []string {
+	var appIds []string
+	if plan == nil {
+		return appIds
+	}
+
+	for _, step := range plan.Steps {
+		for _, action := range step.Actions {
+			appIds = append(appIds, action.App)
+		}
+	}
+	return appIds
+}
+
+// DecodeEvent decodes an event read from the event stream into its go-marathon type, it returns nil
+// for the event types the updater doesn't act on
+func DecodeEvent(event *Event) (*marathon.Event, error) {
+	decoded := &marathon.Event{Name: event.Type}
+	switch event.Type {
+	case StatusUpdateEvent:
+		decoded.ID = marathon.EventIDStatusUpdate
+		decoded.Event = &marathon.EventStatusUpdate{}
+	case DeploymentSuccessEvent:
+		decoded.ID = marathon.EventIDDeploymentSuccess
+		decoded.Event = &DeploymentEvent{}
+	case DeploymentFailedEvent:
+		decoded.ID = marathon.EventIDDeploymentFailed
+		decoded.Event = &DeploymentEvent{}
+	default:
+		return nil, nil
+	}
+
+	if err := json.Unmarshal(event.Data, decoded.Event); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
@@ -1,6 +1,8 @@
-package main
+// Package marathon reads the status update and deployment events from the Marathon event stream
+package marathon
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -11,8 +13,8 @@ import (
 	"net/http"
 	"strings"
 	"time"
-	//"bufio"
-	"bufio"
+
+	marathon "github.com/gambol99/go-marathon"
 )
 
 const (
@@ -158,18 +160,46 @@ type AppResponse struct {
 	} `json:"app"`
 }
 
-type MarathonAPI struct {
+type API struct {
 	Client *http.Client
 	Host   string
-	Path   string
+	// Hosts are cycled through, starting with Host, when the event stream of Host drops
+	Hosts []string
+	Path  string
+	// OnConnect, if set, is called with the host whenever the event stream connects
+	OnConnect func(host string)
+	// LogEvent, if set, is called with every event read from the event stream and its go-marathon
+	// type, which is nil for the event types the updater doesn't act on
+	LogEvent func(event *Event, decoded *marathon.Event)
+}
+
+// StreamError reports a drop of the event stream, events may have been missed. It is fatal once the
+// stream can't be reconnected.
+type StreamError struct {
+	Err     error
+	IsFatal bool
 }
 
-func (api *MarathonAPI) urlForPath(path []string) string {
+func (e *StreamError) Error() string {
+	return e.Err.Error()
+}
+
+// nextHost switches Host to the host after it in Hosts, round-robin
+func (api *API) nextHost() {
+	for idx, host := range api.Hosts {
+		if host == api.Host {
+			api.Host = api.Hosts[(idx+1)%len(api.Hosts)]
+			return
+		}
+	}
+}
+
+func (api *API) urlForPath(path []string) string {
 	fullPath := append([]string{api.Host, api.Path}, path...)
 	return strings.Join(fullPath, "/")
 }
 
-func (api *MarathonAPI) rawRequest(method string, path []string, body interface{}) (*http.Request, error) {
+func (api *API) rawRequest(method string, path []string, body interface{}) (*http.Request, error) {
 	url := api.urlForPath(path)
 	bodyJson, err := json.Marshal(body)
 
@@ -187,7 +217,7 @@ func (api *MarathonAPI) rawRequest(method string, path []string, body interface{
 	return req, nil
 }
 
-func (api *MarathonAPI) doRequest(method string, path []string, body interface{}) (*http.Response, error) {
+func (api *API) doRequest(method string, path []string, body interface{}) (*http.Response, error) {
 	req, err := api.rawRequest(method, path, body)
 
 	if err != nil {
@@ -197,7 +227,7 @@ func (api *MarathonAPI) doRequest(method string, path []string, body interface{}
 	return api.Client.Do(req)
 }
 
-func (api *MarathonAPI) getApp(appId string) (*AppResponse, error) {
+func (api *API) getApp(appId string) (*AppResponse, error) {
 	resp, err := api.doRequest("GET", []string{"apps", appId}, nil)
 
 	if err != nil {
@@ -223,7 +253,7 @@ func (api *MarathonAPI) getApp(appId string) (*AppResponse, error) {
 	return &app, nil
 }
 
-func (api *MarathonAPI) getEvents(events chan<- *Event, errs chan<- *error, ctx context.Context) error {
+func (api *API) getEvents(events chan<- *Event, errs chan<- *error, ctx context.Context) error {
 	req, err := api.rawRequest("GET", []string{"events"}, nil)
 	streamingClient := *api.Client
 	streamingClient.Timeout = 0
@@ -239,6 +269,11 @@ func (api *MarathonAPI) getEvents(events chan<- *Event, errs chan<- *error, ctx
 		return err
 	}
 
+	if (resp.StatusCode / 100) != 2 {
+		resp.Body.Close()
+		return fmt.Errorf("Received non-2XX status %s from the event stream", resp.Status)
+	}
+
 	sendError := func(err error) {
 		select {
 		case errs <- &err:
@@ -247,16 +282,28 @@ func (api *MarathonAPI) getEvents(events chan<- *Event, errs chan<- *error, ctx
 		}
 	}
 
+	// Closing the body unblocks any pending read once we're cancelled
+	go func() {
+		<-ctx.Done()
+		resp.Body.Close()
+	}()
+
 	go func() {
 		rdr := bufio.NewReader(resp.Body)
 		for {
+			if ctx.Err() != nil {
+				log.Println("DEBUG: getEvents received cancel")
+				return
+			}
+
 			// Read event header
 			eventPart, err := rdr.ReadString('\n')
 			if err != nil {
+				// The stream is gone, the caller has to reconnect
 				sendError(err)
-				continue
+				return
 			} else if eventPart == "\r\n" {
-				log.Println("Received KEEPALIVE")
+				log.Println("DEBUG: Received KEEPALIVE")
 				continue
 			}
 			eventParsed := strings.SplitN(eventPart, ":", 2)
@@ -266,7 +313,7 @@ func (api *MarathonAPI) getEvents(events chan<- *Event, errs chan<- *error, ctx
 			dataPart, err := rdr.ReadString('\n')
 			if err != nil {
 				sendError(err)
-				continue
+				return
 			} else if dataPart == "\r\n" {
 				sendError(errors.New(
 					fmt.Sprintf("Expected data part after reading event but got CRLF")))
@@ -278,13 +325,12 @@ func (api *MarathonAPI) getEvents(events chan<- *Event, errs chan<- *error, ctx
 			// Read CRLF delimiter
 			if delim, err := rdr.ReadString('\n'); err != nil {
 				sendError(err)
-				continue
+				return
 			} else if delim != "\r\n" {
 				sendError(errors.New(
 					fmt.Sprintf("Expected CRLF after message but got %b", []byte(delim))))
 			}
 
-			log.Printf("Received eventType: %s", eventType)
 			event := &Event{
 				Type: eventType,
 				Data: data,
@@ -292,7 +338,7 @@ func (api *MarathonAPI) getEvents(events chan<- *Event, errs chan<- *error, ctx
 
 			select {
 			case <-ctx.Done():
-				log.Println("getEvents received cancel")
+				log.Println("DEBUG: getEvents received cancel")
 				return
 			case events <- event:
 				continue
@@ -302,3 +348,108 @@ func (api *MarathonAPI) getEvents(events chan<- *Event, errs chan<- *error, ctx
 
 	return nil
 }
+
+// ReconnectPolicy is the exponential back-off between attempts to reconnect to the event stream
+type ReconnectPolicy struct {
+	Delay       time.Duration
+	MaxDelay    time.Duration
+	MaxAttempts int // 0 is unlimited
+}
+
+// Backoff returns the back-off before the given (one based) reconnect attempt
+func (p ReconnectPolicy) Backoff(attempt int) time.Duration {
+	delay := p.Delay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// StreamEvents sends the status update and deployment success events of the event stream to events
+// until ctx is cancelled, reconnecting according to policy whenever the stream drops. Every drop is
+// reported on errs as a non-fatal error, since events may have been missed, and a fatal error is
+// reported once policy.MaxAttempts reconnect attempts in a row have failed. With several Hosts each
+// reconnect attempt goes to the next one, e.g. the new leader after a Marathon leader election.
+func (api *API) StreamEvents(ctx context.Context, policy ReconnectPolicy, events marathon.EventsChannel, errs chan<- *StreamError) {
+	attempt := 0
+	for {
+		streamCtx, cancel := context.WithCancel(ctx)
+		rawEvents := make(chan *Event)
+		streamErrs := make(chan *error, 1)
+
+		err := api.getEvents(rawEvents, streamErrs, streamCtx)
+		if err == nil {
+			log.Printf("Connected to the Marathon event stream of %s", api.Host)
+			if api.OnConnect != nil {
+				api.OnConnect(api.Host)
+			}
+			attempt = 0
+			err = api.forwardEvents(streamCtx, rawEvents, streamErrs, events)
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		attempt++
+		if policy.MaxAttempts > 0 && attempt > policy.MaxAttempts {
+			select {
+			case <-ctx.Done():
+			case errs <- &StreamError{
+				Err:     fmt.Errorf("Unable to reconnect to the Marathon event stream after %d attempts: %v", policy.MaxAttempts, err),
+				IsFatal: true,
+			}:
+			}
+			return
+		}
+
+		// A pending drop already triggers an update, so there's no need to block on errs
+		select {
+		case errs <- &StreamError{Err: fmt.Errorf("Marathon event stream failed: %v", err), IsFatal: false}:
+		default:
+		}
+
+		api.nextHost()
+		delay := policy.Backoff(attempt)
+		log.Printf("Reconnecting to the Marathon event stream of %s, attempt %d in %v", api.Host, attempt, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// forwardEvents decodes the events read from a single connection to the event stream and sends the
+// ones the updater acts on to events. It returns the error that ended the stream, or nil if ctx is
+// cancelled.
+func (api *API) forwardEvents(ctx context.Context, rawEvents <-chan *Event, errs <-chan *error, events marathon.EventsChannel) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			return *err
+		case rawEvent := <-rawEvents:
+			event, err := DecodeEvent(rawEvent)
+			if err != nil {
+				log.Printf("WARNING: Unable to decode %s event: %v", rawEvent.Type, err)
+				continue
+			}
+			if api.LogEvent != nil {
+				api.LogEvent(rawEvent, event)
+			}
+			if event == nil {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case events <- event:
+			}
+		}
+	}
+}
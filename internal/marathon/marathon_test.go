@@ -0,0 +1,68 @@
+package marathon_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	marathonapi "github.com/DigDug101/marathon-dns-updater/internal/marathon"
+	"github.com/DigDug101/marathon-dns-updater/internal/testutil"
+	marathon "github.com/gambol99/go-marathon"
+)
+
+func TestStreamEventsReconnects(t *testing.T) {
+	server := testutil.NewMockMarathonServer()
+	defer server.Close()
+
+	connects := make(chan string, 4)
+	api := &marathonapi.API{
+		Client:    server.Client(),
+		Host:      server.URL,
+		Hosts:     []string{server.URL},
+		Path:      "v2",
+		OnConnect: func(host string) { connects <- host },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(marathon.EventsChannel, 1)
+	errs := make(chan *marathonapi.StreamError, 1)
+	go api.StreamEvents(ctx, marathonapi.ReconnectPolicy{Delay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond, MaxAttempts: 3}, events, errs)
+
+	waitForConnect := func() {
+		t.Helper()
+		select {
+		case <-connects:
+		case err := <-errs:
+			t.Fatalf("Event stream failed: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("The event stream didn't connect")
+		}
+	}
+	waitForConnect()
+
+	// Marathon restarts, the drop is reported as events may have been missed
+	server.DropEventStreams()
+	select {
+	case err := <-errs:
+		if err.IsFatal {
+			t.Fatalf("Expected the drop to be non-fatal, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The drop of the event stream wasn't reported")
+	}
+	waitForConnect()
+
+	if err := server.FireStatusUpdate("/marathon-lb", "marathon-lb.a", marathonapi.TaskRunning, "agent-10.0.0.1", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-events:
+		if ids := marathonapi.EventAppIds(event); len(ids) != 1 || ids[0] != "/marathon-lb" {
+			t.Errorf("Expected the status update of /marathon-lb, got %v", ids)
+		}
+	case err := <-errs:
+		t.Fatalf("Event stream failed: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event received after reconnecting")
+	}
+}
@@ -0,0 +1,445 @@
+package route53
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/config"
+	"github.com/DigDug101/marathon-dns-updater/internal/dns"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+const (
+	// ListTagsForResources accepts at most 10 resources per request
+	HEALTH_CHECK_TAGS_BATCH = 10
+)
+
+// healthCheckTags are the tags identifying the health checks created for the records of recordSet
+func healthCheckTags(recordSet string) []*route53.Tag {
+	return []*route53.Tag{
+		{Key: aws.String(config.HEALTH_CHECK_MANAGED_BY_TAG), Value: aws.String(config.HEALTH_CHECK_MANAGED_BY)},
+		{Key: aws.String(config.HEALTH_CHECK_RECORD_SET_TAG), Value: aws.String(recordSet)},
+	}
+}
+
+// desiredHealthCheckTags are the tags of the health checks created for the records of recordSet: the
+// tags identifying them followed by health-check-tags
+func desiredHealthCheckTags(cfg config.Config, recordSet string) []*route53.Tag {
+	tags := healthCheckTags(recordSet)
+	var keys []string
+	for key := range cfg.HealthCheckTags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		tags = append(tags, &route53.Tag{Key: aws.String(key), Value: aws.String(cfg.HealthCheckTags[key])})
+	}
+	return tags
+}
+
+// TaggedHealthCheck is a health check along with its tags
+type TaggedHealthCheck struct {
+	HealthCheck *route53.HealthCheck
+	Tags        []*route53.Tag
+}
+
+// EnsureHealthChecks returns the ids of the health checks of the weighted records of recordSet by IP,
+// creating a health check for every IP that doesn't have one yet. The ids of the health checks that
+// were created for recordSet before but whose IP is no longer in ips, or that are stale, are returned
+// as orphaned.
+func (p *Provider) EnsureHealthChecks(ctx context.Context, cfg config.Config, recordSet string, ips []string) (map[string]string, []string, error) {
+	existing, orphaned, err := p.managedHealthChecks(ctx, cfg, recordSet)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to list health checks: %v", err)
+	}
+
+	healthCheckIds := map[string]string{}
+	for _, ip := range ips {
+		if id, ok := existing[ip]; ok {
+			healthCheckIds[ip] = id
+			delete(existing, ip)
+			continue
+		}
+
+		id, err := p.createHealthCheck(ctx, cfg, recordSet, ip)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to create health check for %s: %v", ip, err)
+		}
+		healthCheckIds[ip] = id
+	}
+
+	for _, id := range existing {
+		orphaned = append(orphaned, id)
+	}
+	return healthCheckIds, orphaned, nil
+}
+
+// managedHealthChecks returns the ids by IP of the health checks tagged as created for recordSet
+// that match the configured port, path and protocol, reconciling their tags with health-check-tags.
+// The ids of the other health checks tagged for recordSet are returned as stale: those created before
+// the port, path or protocol changed, and every health check of an IP beyond the first.
+func (p *Provider) managedHealthChecks(ctx context.Context, cfg config.Config, recordSet string) (map[string]string, []string, error) {
+	tagged, err := p.TaggedHealthChecks(ctx, recordSet, func(healthCheck *route53.HealthCheck) bool {
+		return healthCheck.HealthCheckConfig != nil && healthCheck.HealthCheckConfig.IPAddress != nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	managed := map[string]string{}
+	var stale []string
+	for _, check := range tagged {
+		id := *check.HealthCheck.Id
+		config := check.HealthCheck.HealthCheckConfig
+		ip := *config.IPAddress
+		if _, ok := managed[ip]; ok ||
+			aws.StringValue(config.Type) != cfg.HealthCheckProtocol ||
+			aws.Int64Value(config.Port) != cfg.HealthCheckPort ||
+			aws.StringValue(config.ResourcePath) != cfg.HealthCheckPath {
+			stale = append(stale, id)
+			continue
+		}
+		managed[ip] = id
+		if err := p.reconcileHealthCheckTags(ctx, check, desiredHealthCheckTags(cfg, recordSet)); err != nil {
+			log.Printf("WARNING: Unable to update the tags of health check %s: %v", id, err)
+		}
+	}
+	return managed, stale, nil
+}
+
+// TaggedHealthChecks returns the health checks among those selected by match that are tagged as
+// created for recordSet, whatever their configuration, ordered by id
+func (p *Provider) TaggedHealthChecks(ctx context.Context, recordSet string, match func(*route53.HealthCheck) bool) ([]TaggedHealthCheck, error) {
+	p.healthChecks.mu.Lock()
+	defer p.healthChecks.mu.Unlock()
+	if err := p.listHealthChecks(ctx); err != nil {
+		return nil, err
+	}
+
+	var tagged []TaggedHealthCheck
+	for _, check := range p.healthChecks.checks {
+		if match(check.HealthCheck) && hasTags(check.Tags, healthCheckTags(recordSet)) {
+			tagged = append(tagged, check)
+		}
+	}
+	sort.Slice(tagged, func(i, j int) bool { return *tagged[i].HealthCheck.Id < *tagged[j].HealthCheck.Id })
+	return tagged, nil
+}
+
+// healthCheckListing caches the health checks tagged managed-by=marathon-dns-updater along with their
+// tags. Listing them takes a request per 100 health checks of the account and another per 10 for
+// their tags, so they are listed once per update cycle rather than for every record set, and the
+// changes the updater makes to them are applied to the cache as well.
+type healthCheckListing struct {
+	mu sync.Mutex
+	// checks is nil until the health checks are listed
+	checks map[string]TaggedHealthCheck
+}
+
+// listHealthChecks lists the health checks created by the updater into the cache, unless they are
+// cached already. It has to be called with the mutex of the cache held.
+func (p *Provider) listHealthChecks(ctx context.Context) error {
+	if p.healthChecks.checks != nil {
+		return nil
+	}
+
+	healthChecks := map[string]*route53.HealthCheck{}
+	var ids []*string
+	input := &route53.ListHealthChecksInput{}
+	for {
+		if err := p.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		output, err := p.Client.ListHealthChecksWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		for _, healthCheck := range output.HealthChecks {
+			healthChecks[*healthCheck.Id] = healthCheck
+			ids = append(ids, healthCheck.Id)
+		}
+		if !aws.BoolValue(output.IsTruncated) {
+			break
+		}
+		input = &route53.ListHealthChecksInput{Marker: output.NextMarker}
+	}
+
+	managedBy := healthCheckTags("")[:1]
+	checks := map[string]TaggedHealthCheck{}
+	for start := 0; start < len(ids); start += HEALTH_CHECK_TAGS_BATCH {
+		end := start + HEALTH_CHECK_TAGS_BATCH
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if err := p.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		output, err := p.Client.ListTagsForResourcesWithContext(ctx, &route53.ListTagsForResourcesInput{
+			ResourceType: aws.String(route53.TagResourceTypeHealthcheck),
+			ResourceIds:  ids[start:end],
+		})
+		if err != nil {
+			return err
+		}
+		for _, tagSet := range output.ResourceTagSets {
+			healthCheck, ok := healthChecks[aws.StringValue(tagSet.ResourceId)]
+			if !ok || !hasTags(tagSet.Tags, managedBy) {
+				continue
+			}
+			checks[*healthCheck.Id] = TaggedHealthCheck{HealthCheck: healthCheck, Tags: tagSet.Tags}
+		}
+	}
+
+	log.Printf("DEBUG: Listed %d health checks, %d of them created by the updater", len(ids), len(checks))
+	p.healthChecks.checks = checks
+	return nil
+}
+
+// ResetHealthChecks drops the cached health checks, so they are listed again once they are needed
+func (p *Provider) ResetHealthChecks() {
+	p.healthChecks.mu.Lock()
+	p.healthChecks.checks = nil
+	p.healthChecks.mu.Unlock()
+}
+
+// cacheHealthCheck stores a health check the updater created or changed in the cache, keeping the
+// cached tags if tags is nil
+func (p *Provider) cacheHealthCheck(healthCheck *route53.HealthCheck, tags []*route53.Tag) {
+	p.healthChecks.mu.Lock()
+	defer p.healthChecks.mu.Unlock()
+	if p.healthChecks.checks == nil {
+		return
+	}
+	if tags == nil {
+		tags = p.healthChecks.checks[*healthCheck.Id].Tags
+	}
+	p.healthChecks.checks[*healthCheck.Id] = TaggedHealthCheck{HealthCheck: healthCheck, Tags: tags}
+}
+
+// uncacheHealthCheck removes a deleted health check from the cache
+func (p *Provider) uncacheHealthCheck(id string) {
+	p.healthChecks.mu.Lock()
+	defer p.healthChecks.mu.Unlock()
+	delete(p.healthChecks.checks, id)
+}
+
+// createHealthCheck creates and tags a health check for ip and returns its id
+func (p *Provider) createHealthCheck(ctx context.Context, cfg config.Config, recordSet string, ip string) (string, error) {
+	// The caller reference has to be unique, even across deleted health checks
+	id, err := p.createTaggedHealthCheck(ctx, cfg, recordSet, fmt.Sprintf("%s-%d", ip, time.Now().UnixNano()), &route53.HealthCheckConfig{
+		IPAddress:    aws.String(ip),
+		Port:         aws.Int64(cfg.HealthCheckPort),
+		Type:         aws.String(cfg.HealthCheckProtocol),
+		ResourcePath: aws.String(cfg.HealthCheckPath),
+	})
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Created health check %s for %s", id, ip)
+	return id, nil
+}
+
+// createTaggedHealthCheck creates a health check with config and tags it as created for recordSet.
+// The health checks are only found again by their tags, so one that can't be tagged is deleted
+// rather than left behind.
+func (p *Provider) createTaggedHealthCheck(ctx context.Context, cfg config.Config, recordSet string, callerReference string, config *route53.HealthCheckConfig) (string, error) {
+	release, err := p.Throttle(ctx)
+	if err != nil {
+		return "", err
+	}
+	output, err := p.Client.CreateHealthCheckWithContext(ctx, &route53.CreateHealthCheckInput{
+		CallerReference:   aws.String(callerReference),
+		HealthCheckConfig: config,
+	})
+	release()
+	if err != nil {
+		return "", err
+	}
+	id := *output.HealthCheck.Id
+	tags := desiredHealthCheckTags(cfg, recordSet)
+
+	release, err = p.Throttle(ctx)
+	if err == nil {
+		_, err = p.Client.ChangeTagsForResourceWithContext(ctx, &route53.ChangeTagsForResourceInput{
+			ResourceType: aws.String(route53.TagResourceTypeHealthcheck),
+			ResourceId:   aws.String(id),
+			AddTags:      tags,
+		})
+		release()
+	}
+	if err != nil {
+		// The context may be done already, the deletion must not depend on it
+		p.DeleteHealthChecks(context.Background(), []string{id})
+		return "", fmt.Errorf("Unable to tag health check %s: %v", id, err)
+	}
+	p.cacheHealthCheck(output.HealthCheck, tags)
+	return id, nil
+}
+
+// reconcileHealthCheckTags adds the tags of want that check is missing, or has a different value of, and
+// removes the tags it has beyond want, e.g. once they are dropped from health-check-tags
+func (p *Provider) reconcileHealthCheckTags(ctx context.Context, check TaggedHealthCheck, want []*route53.Tag) error {
+	id := *check.HealthCheck.Id
+	tags := check.Tags
+	input := &route53.ChangeTagsForResourceInput{
+		ResourceType: aws.String(route53.TagResourceTypeHealthcheck),
+		ResourceId:   aws.String(id),
+	}
+	wanted := map[string]bool{}
+	for _, tag := range want {
+		wanted[*tag.Key] = true
+		if !hasTags(tags, []*route53.Tag{tag}) {
+			input.AddTags = append(input.AddTags, tag)
+		}
+	}
+	for _, tag := range tags {
+		if !wanted[aws.StringValue(tag.Key)] {
+			input.RemoveTagKeys = append(input.RemoveTagKeys, tag.Key)
+		}
+	}
+	if len(input.AddTags) == 0 && len(input.RemoveTagKeys) == 0 {
+		return nil
+	}
+
+	release, err := p.Throttle(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = p.Client.ChangeTagsForResourceWithContext(ctx, input)
+	release()
+	if err != nil {
+		return err
+	}
+	p.cacheHealthCheck(check.HealthCheck, want)
+	log.Printf("Updated the tags of health check %s, %d added or changed, %d removed", id, len(input.AddTags), len(input.RemoveTagKeys))
+	return nil
+}
+
+// DeleteHealthChecks deletes the health checks of records that have been deleted
+func (p *Provider) DeleteHealthChecks(ctx context.Context, ids []string) {
+	for _, id := range ids {
+		release, err := p.Throttle(ctx)
+		if err != nil {
+			log.Printf("WARNING: Unable to delete health check %s: %v", id, err)
+			continue
+		}
+		_, err = p.Client.DeleteHealthCheckWithContext(ctx, &route53.DeleteHealthCheckInput{HealthCheckId: aws.String(id)})
+		release()
+		if ErrorCode(err) == route53.ErrCodeNoSuchHealthCheck {
+			p.uncacheHealthCheck(id)
+		}
+		if err != nil {
+			log.Printf("WARNING: Unable to delete health check %s: %v", id, err)
+			continue
+		}
+		p.uncacheHealthCheck(id)
+		log.Printf("Deleted health check %s", id)
+	}
+}
+
+// EnsureCalculatedHealthCheck returns the id of the calculated health check of recordSet that is
+// healthy while at least one of children is, creating it or updating its children and tags as needed.
+// The ids of the other calculated health checks tagged for recordSet are returned as orphaned.
+func (p *Provider) EnsureCalculatedHealthCheck(ctx context.Context, cfg config.Config, recordSet string, children []string) (string, []string, error) {
+	calculated, err := p.CalculatedHealthChecks(ctx, recordSet)
+	if err != nil {
+		return "", nil, fmt.Errorf("Unable to list health checks: %v", err)
+	}
+
+	if len(calculated) > 0 {
+		var orphaned []string
+		for _, check := range calculated[1:] {
+			orphaned = append(orphaned, *check.HealthCheck.Id)
+		}
+
+		healthCheck := calculated[0].HealthCheck
+		if err := p.reconcileHealthCheckTags(ctx, calculated[0], desiredHealthCheckTags(cfg, recordSet)); err != nil {
+			log.Printf("WARNING: Unable to update the tags of health check %s: %v", *healthCheck.Id, err)
+		}
+		if !dns.SameStrings(aws.StringValueSlice(healthCheck.HealthCheckConfig.ChildHealthChecks), children) {
+			release, err := p.Throttle(ctx)
+			if err != nil {
+				return "", nil, err
+			}
+			output, err := p.Client.UpdateHealthCheckWithContext(ctx, &route53.UpdateHealthCheckInput{
+				HealthCheckId:     healthCheck.Id,
+				ChildHealthChecks: aws.StringSlice(children),
+				HealthThreshold:   aws.Int64(1),
+			})
+			release()
+			if err != nil {
+				return "", nil, fmt.Errorf("Unable to update health check %s: %v", *healthCheck.Id, err)
+			}
+			p.cacheHealthCheck(output.HealthCheck, nil)
+			log.Printf("Updated children of health check %s to %v", *healthCheck.Id, children)
+		}
+		return *healthCheck.Id, orphaned, nil
+	}
+
+	id, err := p.createTaggedHealthCheck(ctx, cfg, recordSet, fmt.Sprintf("calculated-%d", time.Now().UnixNano()), &route53.HealthCheckConfig{
+		Type:              aws.String(route53.HealthCheckTypeCalculated),
+		ChildHealthChecks: aws.StringSlice(children),
+		HealthThreshold:   aws.Int64(1),
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("Unable to create calculated health check: %v", err)
+	}
+	log.Printf("Created calculated health check %s for %s", id, recordSet)
+	return id, nil, nil
+}
+
+// CalculatedHealthChecks returns the calculated health checks tagged as created for recordSet
+func (p *Provider) CalculatedHealthChecks(ctx context.Context, recordSet string) ([]TaggedHealthCheck, error) {
+	return p.TaggedHealthChecks(ctx, recordSet, IsCalculatedHealthCheck)
+}
+
+// IsCalculatedHealthCheck reports whether healthCheck is a calculated health check, which refers to
+// other health checks rather than an IP
+func IsCalculatedHealthCheck(healthCheck *route53.HealthCheck) bool {
+	return healthCheck.HealthCheckConfig != nil && aws.StringValue(healthCheck.HealthCheckConfig.Type) == route53.HealthCheckTypeCalculated
+}
+
+// SetHealthCheckIds associates the weighted, latency and multivalue record sets among upserts with
+// the health check of their IP
+func SetHealthCheckIds(upserts []*route53.Change, healthCheckIds map[string]string) {
+	for _, upsert := range upserts {
+		recordSet := upsert.ResourceRecordSet
+		identifier := aws.StringValue(recordSet.SetIdentifier)
+		if !strings.HasPrefix(identifier, "weighted-") && !strings.HasPrefix(identifier, "latency-") &&
+			!strings.HasPrefix(identifier, "multivalue-") || len(recordSet.ResourceRecords) == 0 {
+			continue
+		}
+		if id, ok := healthCheckIds[*recordSet.ResourceRecords[0].Value]; ok {
+			recordSet.HealthCheckId = aws.String(id)
+		}
+	}
+}
+
+// SetFailoverHealthCheckId associates the primary failover record set among upserts with a health check
+func SetFailoverHealthCheckId(upserts []*route53.Change, healthCheckId string) {
+	for _, upsert := range upserts {
+		if aws.StringValue(upsert.ResourceRecordSet.Failover) == route53.ResourceRecordSetFailoverPrimary {
+			upsert.ResourceRecordSet.HealthCheckId = aws.String(healthCheckId)
+		}
+	}
+}
+
+// hasTags reports whether every tag of want is in tags
+func hasTags(tags []*route53.Tag, want []*route53.Tag) bool {
+	values := map[string]string{}
+	for _, tag := range tags {
+		values[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	for _, tag := range want {
+		if value, ok := values[*tag.Key]; !ok || value != *tag.Value {
+			return false
+		}
+	}
+	return true
+}
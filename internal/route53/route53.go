@@ -0,0 +1,280 @@
+// Package route53 manages records and their health checks in a Route53 hosted zone
+package route53
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/dns"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	"golang.org/x/time/rate"
+)
+
+// Provider manages records in a Route53 hosted zone. updateRecords submits all changes for an
+// app in a single change batch through client, the DNSProvider methods apply one change at a time.
+//
+// Changes are rate limited to rps per second, shared by every app, to stay below the Route53 API quota.
+// The record sets of the apps are listed concurrently, but by at most listConcurrency apps at once,
+// and at most changeConcurrency changes are submitted at once.
+type Provider struct {
+	Client       route53iface.Route53API
+	hostedZoneId string
+	limiter      *rate.Limiter
+	listSlots    chan struct{}
+	changeSlots  chan struct{}
+	healthChecks healthCheckListing
+}
+
+func NewProvider(hostedZoneId string, awsConfig *aws.Config, rps float64, listConcurrency int, changeConcurrency int) *Provider {
+	sess := session.Must(session.NewSession(awsConfig))
+	return &Provider{
+		Client:       route53.New(sess),
+		hostedZoneId: hostedZoneId,
+		limiter:      rate.NewLimiter(rate.Limit(rps), 1),
+		listSlots:    make(chan struct{}, listConcurrency),
+		changeSlots:  make(chan struct{}, changeConcurrency),
+	}
+}
+
+// VerifyPrivateZones returns an error unless every one of zoneIds is a private hosted zone associated
+// with the VPC of the EC2 instance the updater runs on, as read from the instance metadata
+func (p *Provider) VerifyPrivateZones(zoneIds []string) error {
+	vpcID, region, err := instanceVPC()
+	if err != nil {
+		return fmt.Errorf("Unable to read the VPC of this instance from the instance metadata: %v", err)
+	}
+
+	for _, zoneId := range zoneIds {
+		output, err := p.Client.GetHostedZone(&route53.GetHostedZoneInput{Id: aws.String(zoneId)})
+		if err != nil {
+			return fmt.Errorf("Unable to get hosted zone %s: %v", zoneId, err)
+		}
+		if output.HostedZone.Config == nil || !aws.BoolValue(output.HostedZone.Config.PrivateZone) {
+			return fmt.Errorf("Hosted zone %s is not a private zone", zoneId)
+		}
+
+		associated := false
+		for _, vpc := range output.VPCs {
+			if aws.StringValue(vpc.VPCId) == vpcID && aws.StringValue(vpc.VPCRegion) == region {
+				associated = true
+			}
+		}
+		if !associated {
+			return fmt.Errorf("Private hosted zone %s is not associated with VPC %s in %s", zoneId, vpcID, region)
+		}
+		log.Printf("Private hosted zone %s is associated with VPC %s", zoneId, vpcID)
+	}
+	return nil
+}
+
+// instanceVPC returns the VPC id and region of the EC2 instance the updater runs on
+func instanceVPC() (string, string, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return "", "", err
+	}
+	metadata := ec2metadata.New(sess)
+
+	document, err := metadata.GetInstanceIdentityDocument()
+	if err != nil {
+		return "", "", err
+	}
+	mac, err := metadata.GetMetadata("mac")
+	if err != nil {
+		return "", "", err
+	}
+	vpcID, err := metadata.GetMetadata("network/interfaces/macs/" + mac + "/vpc-id")
+	if err != nil {
+		return "", "", err
+	}
+	return vpcID, document.Region, nil
+}
+
+// Throttle blocks until one of the route53-concurrency change slots is free and the rate limit
+// allows another change to be submitted. The returned func releases the slot once the change has
+// been submitted. It gives up with the error of ctx once ctx is cancelled, e.g. by update-timeout.
+func (p *Provider) Throttle(ctx context.Context) (func(), error) {
+	select {
+	case p.changeSlots <- struct{}{}:
+	default:
+		log.Printf("DEBUG: Waiting for one of %d Route53 change slots", cap(p.changeSlots))
+		select {
+		case p.changeSlots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	start := time.Now()
+	if err := p.limiter.Wait(ctx); err != nil {
+		<-p.changeSlots
+		return nil, err
+	}
+	if waited := time.Since(start); waited >= time.Millisecond {
+		log.Printf("DEBUG: Rate limited Route53 change, waited %v", waited)
+	}
+	return func() { <-p.changeSlots }, nil
+}
+
+// ListRecordSets lists the record sets of the hosted zone hostedZoneID from recordSet on, once one
+// of the listConcurrency slots is free. Route53 returns at most 300 record sets per page, the pages
+// are listed until the names of recordSet and its enumerated names have all been listed. Like
+// Throttle it gives up waiting for a slot once ctx is cancelled.
+func (p *Provider) ListRecordSets(ctx context.Context, hostedZoneID string, recordSet string) ([]*route53.ResourceRecordSet, error) {
+	select {
+	case p.listSlots <- struct{}{}:
+	default:
+		log.Printf("DEBUG: Waiting for one of %d Route53 list slots", cap(p.listSlots))
+		select {
+		case p.listSlots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	defer func() { <-p.listSlots }()
+
+	input := &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(hostedZoneID),
+		StartRecordName: aws.String(recordSet),
+		StartRecordType: aws.String(route53.RRTypeA),
+	}
+	var recordSets []*route53.ResourceRecordSet
+	for {
+		output, err := p.Client.ListResourceRecordSetsWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		recordSets = append(recordSets, output.ResourceRecordSets...)
+		if !aws.BoolValue(output.IsTruncated) || !withinRecordSetNames(recordSet, aws.StringValue(output.NextRecordName)) {
+			return recordSets, nil
+		}
+		input = &route53.ListResourceRecordSetsInput{
+			HostedZoneId:          input.HostedZoneId,
+			StartRecordName:       output.NextRecordName,
+			StartRecordType:       output.NextRecordType,
+			StartRecordIdentifier: output.NextRecordIdentifier,
+		}
+	}
+}
+
+// withinRecordSetNames reports whether name, listed after recordSet, may still be followed by
+// recordSet or one of its enumerated names. Route53 lists record sets ordered by their name with the
+// labels reversed, e.g. com.example.marathon-lb-1, so the names in the same parent domain whose
+// label in place of the first label of recordSet starts with it are listed one after the other.
+func withinRecordSetNames(recordSet string, name string) bool {
+	recordSetLabels := strings.Split(strings.ToLower(strings.TrimSuffix(recordSet, ".")), ".")
+	nameLabels := strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".")
+	offset := len(nameLabels) - len(recordSetLabels)
+	if offset < 0 {
+		return false
+	}
+	for idx := 1; idx < len(recordSetLabels); idx++ {
+		if nameLabels[offset+idx] != recordSetLabels[idx] {
+			return false
+		}
+	}
+	return strings.HasPrefix(nameLabels[offset], recordSetLabels[0])
+}
+
+func (p *Provider) ListRecords(recordSet string, aaaaPrefix string) ([]dns.DNSRecord, error) {
+	var records []dns.DNSRecord
+	input := &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(p.hostedZoneId),
+		StartRecordName: aws.String(recordSet),
+		StartRecordType: aws.String(route53.RRTypeA),
+	}
+
+	err := p.Client.ListResourceRecordSetsPages(input, func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+		for _, recordSet := range page.ResourceRecordSets {
+			if !dns.IsManagedRecordType(*recordSet.Type) {
+				continue
+			}
+			if !dns.IsManagedRecordName(*input.StartRecordName, *recordSet.Name, aaaaPrefix) {
+				continue
+			}
+			for _, record := range recordSet.ResourceRecords {
+				dnsRecord := RecordFromResourceRecordSet(recordSet)
+				dnsRecord.Value = *record.Value
+				records = append(records, dnsRecord)
+			}
+		}
+		return withinRecordSetNames(*input.StartRecordName, aws.StringValue(page.NextRecordName))
+	})
+
+	return records, err
+}
+
+func (p *Provider) UpsertRecord(record dns.DNSRecord) error {
+	return p.change(route53.ChangeActionUpsert, record)
+}
+
+func (p *Provider) DeleteRecord(record dns.DNSRecord) error {
+	return p.change(route53.ChangeActionDelete, record)
+}
+
+func (p *Provider) change(action string, record dns.DNSRecord) error {
+	recordSet := &route53.ResourceRecordSet{
+		Name: aws.String(record.Name),
+		Type: aws.String(record.Type),
+		TTL:  aws.Int64(record.TTL),
+		ResourceRecords: []*route53.ResourceRecord{
+			{Value: aws.String(record.Value)},
+		},
+	}
+	if record.SetIdentifier != "" {
+		recordSet.SetIdentifier = aws.String(record.SetIdentifier)
+		recordSet.Weight = aws.Int64(record.Weight)
+	}
+
+	release, err := p.Throttle(context.Background())
+	if err != nil {
+		return err
+	}
+	defer release()
+	_, err = p.Client.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+		ChangeBatch: &route53.ChangeBatch{
+			Changes: []*route53.Change{
+				{Action: aws.String(action), ResourceRecordSet: recordSet},
+			},
+		},
+		HostedZoneId: aws.String(p.hostedZoneId),
+	})
+	return err
+}
+
+// RecordFromResourceRecordSet flattens a single value Route53 record set into a DNSRecord
+func RecordFromResourceRecordSet(recordSet *route53.ResourceRecordSet) dns.DNSRecord {
+	record := dns.DNSRecord{
+		Name: strings.TrimSuffix(*recordSet.Name, "."),
+		Type: *recordSet.Type,
+	}
+	if recordSet.TTL != nil {
+		record.TTL = *recordSet.TTL
+	}
+	if recordSet.SetIdentifier != nil {
+		record.SetIdentifier = *recordSet.SetIdentifier
+	}
+	if recordSet.Weight != nil {
+		record.Weight = *recordSet.Weight
+	}
+	if len(recordSet.ResourceRecords) > 0 {
+		record.Value = *recordSet.ResourceRecords[0].Value
+	}
+	return record
+}
+
+// ErrorCode returns the AWS error code of err for labelling metrics
+func ErrorCode(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return "unknown"
+}
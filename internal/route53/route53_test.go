@@ -0,0 +1,195 @@
+package route53
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+)
+
+// pagingRoute53 lists its record sets in pages of pageSize like Route53 does: ordered by their name
+// with the labels reversed, then by type and set identifier
+type pagingRoute53 struct {
+	route53iface.Route53API
+
+	pageSize   int
+	recordSets []*route53.ResourceRecordSet
+	// starts holds the StartRecordName of every page requested
+	starts []string
+}
+
+func newPagingRoute53(pageSize int, names ...string) *pagingRoute53 {
+	m := &pagingRoute53{pageSize: pageSize}
+	for _, name := range names {
+		m.recordSets = append(m.recordSets, &route53.ResourceRecordSet{
+			Name:            aws.String(name + "."),
+			Type:            aws.String(route53.RRTypeA),
+			TTL:             aws.Int64(60),
+			ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}},
+		})
+	}
+	sort.Slice(m.recordSets, func(i, j int) bool {
+		return listingKey(m.recordSets[i].Name, m.recordSets[i].Type, m.recordSets[i].SetIdentifier) <
+			listingKey(m.recordSets[j].Name, m.recordSets[j].Type, m.recordSets[j].SetIdentifier)
+	})
+	return m
+}
+
+// listingKey orders record sets the way Route53 lists them
+func listingKey(name *string, recordType *string, identifier *string) string {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(aws.StringValue(name), ".")), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return strings.Join(labels, ".") + " " + aws.StringValue(recordType) + " " + aws.StringValue(identifier)
+}
+
+func (m *pagingRoute53) ListResourceRecordSetsWithContext(ctx aws.Context, input *route53.ListResourceRecordSetsInput, opts ...request.Option) (*route53.ListResourceRecordSetsOutput, error) {
+	m.starts = append(m.starts, aws.StringValue(input.StartRecordName))
+	start := listingKey(input.StartRecordName, input.StartRecordType, input.StartRecordIdentifier)
+	idx := sort.Search(len(m.recordSets), func(i int) bool {
+		return listingKey(m.recordSets[i].Name, m.recordSets[i].Type, m.recordSets[i].SetIdentifier) >= start
+	})
+
+	output := &route53.ListResourceRecordSetsOutput{IsTruncated: aws.Bool(false)}
+	end := idx + m.pageSize
+	if end >= len(m.recordSets) {
+		output.ResourceRecordSets = m.recordSets[idx:]
+		return output, nil
+	}
+	output.ResourceRecordSets = m.recordSets[idx:end]
+	next := m.recordSets[end]
+	output.IsTruncated = aws.Bool(true)
+	output.NextRecordName = next.Name
+	output.NextRecordType = next.Type
+	output.NextRecordIdentifier = next.SetIdentifier
+	return output, nil
+}
+
+func names(recordSets []*route53.ResourceRecordSet) []string {
+	var names []string
+	for _, recordSet := range recordSets {
+		names = append(names, strings.TrimSuffix(aws.StringValue(recordSet.Name), "."))
+	}
+	return names
+}
+
+func TestListRecordSetsPages(t *testing.T) {
+	var zone []string
+	for idx := 1; idx <= 7; idx++ {
+		zone = append(zone, fmt.Sprintf("marathon-lb-%d.example.com", idx))
+	}
+	zone = append(zone, "marathon-lb.example.com", "api.example.com", "zookeeper.example.com", "www.example.com", "marathon-lb.other.com")
+
+	tests := []struct {
+		name      string
+		pageSize  int
+		wantPages int
+	}{
+		{
+			name:      "single page",
+			pageSize:  100,
+			wantPages: 1,
+		},
+		{
+			name:      "several pages",
+			pageSize:  3,
+			wantPages: 3,
+		},
+		{
+			name:      "page per record set",
+			pageSize:  1,
+			wantPages: 8,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newPagingRoute53(test.pageSize, zone...)
+			provider := NewProvider("Z1", aws.NewConfig().WithRegion("us-east-1"), 100, 1, 1)
+			provider.Client = client
+
+			recordSets, err := provider.ListRecordSets(context.Background(), "Z1", "marathon-lb.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			listed := names(recordSets)
+			for _, name := range append([]string{"marathon-lb.example.com"}, zone[:7]...) {
+				found := false
+				for _, listedName := range listed {
+					found = found || listedName == name
+				}
+				if !found {
+					t.Errorf("Expected %s to be listed, got %v", name, listed)
+				}
+			}
+			// No page is requested past the names of the record set
+			for _, start := range client.starts {
+				if !withinRecordSetNames("marathon-lb.example.com", start) {
+					t.Errorf("Expected the listing to stop before %s", start)
+				}
+			}
+			if len(client.starts) != test.wantPages {
+				t.Errorf("Expected %d pages to be requested, got %d starting at %v", test.wantPages, len(client.starts), client.starts)
+			}
+		})
+	}
+}
+
+func TestWithinRecordSetNames(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"marathon-lb.example.com.", true},
+		{"marathon-lb-12.example.com", true},
+		{"Marathon-LB-ipv6-1.example.com.", true},
+		{"marathon-lbx.example.com", true},
+		{"sub.marathon-lb-1.example.com", true},
+		{"www.example.com", false},
+		{"marathon-lb.example.org", false},
+		{"com", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if got := withinRecordSetNames("marathon-lb.example.com", test.name); got != test.want {
+			t.Errorf("withinRecordSetNames(marathon-lb.example.com, %q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestListRecordSetsGivesUpWaitingForASlot(t *testing.T) {
+	provider := NewProvider("Z1", aws.NewConfig().WithRegion("us-east-1"), 100, 1, 1)
+	provider.Client = newPagingRoute53(100, "marathon-lb.example.com")
+
+	// The only list slot is taken by another update
+	provider.listSlots <- struct{}{}
+	defer func() { <-provider.listSlots }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := provider.ListRecordSets(ctx, "Z1", "marathon-lb.example.com")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected the wait for a list slot to time out, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListRecordSets kept waiting for a list slot after its context was done")
+	}
+}
@@ -0,0 +1,248 @@
+// Package testutil holds the fakes the tests of the updater run against
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	marathonapi "github.com/DigDug101/marathon-dns-updater/internal/marathon"
+	marathon "github.com/gambol99/go-marathon"
+)
+
+// MockMarathonServer is an httptest server serving the parts of the Marathon API the updater uses:
+// /ping, /v2/apps, /v2/groups and the event stream at /v2/events. Tests inject the apps and groups it
+// returns and fire events at the connected event streams.
+type MockMarathonServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	apps     map[string]*marathon.Application
+	groups   map[string]*marathon.Group
+	failures int
+	requests []*http.Request
+	streams  map[chan string]bool
+	drop     chan struct{}
+}
+
+// NewMockMarathonServer starts a MockMarathonServer without any apps, it is stopped with Close
+func NewMockMarathonServer() *MockMarathonServer {
+	s := &MockMarathonServer{
+		apps:    map[string]*marathon.Application{},
+		groups:  map[string]*marathon.Group{},
+		streams: map[chan string]bool{},
+		drop:    make(chan struct{}),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Close drops the connected event streams and stops the server
+func (s *MockMarathonServer) Close() {
+	s.DropEventStreams()
+	s.Server.Close()
+}
+
+// SetApp serves app from /v2/apps and /v2/apps/<id>, replacing the app with the same id
+func (s *MockMarathonServer) SetApp(app *marathon.Application) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apps[trimID(app.ID)] = app
+}
+
+// RemoveApp stops serving the app appID, requests for it are answered with 404
+func (s *MockMarathonServer) RemoveApp(appID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.apps, trimID(appID))
+}
+
+// SetGroup serves group from /v2/groups/<id>
+func (s *MockMarathonServer) SetGroup(group *marathon.Group) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groups[trimID(group.ID)] = group
+}
+
+// FailRequests answers the next n requests for apps and groups with a 500, as Marathon does e.g.
+// while it elects a new leader
+func (s *MockMarathonServer) FailRequests(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = n
+}
+
+// Requests returns the requests received so far, oldest first
+func (s *MockMarathonServer) Requests() []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*http.Request(nil), s.requests...)
+}
+
+// EventStreams returns the number of connected event streams
+func (s *MockMarathonServer) EventStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// WaitForEventStreams waits until at least n event streams are connected, it returns false if they
+// aren't within timeout
+func (s *MockMarathonServer) WaitForEventStreams(n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for s.EventStreams() < n {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// DropEventStreams closes the connection of every connected event stream, like a restart of
+// Marathon. Event streams connecting afterwards are served as usual.
+func (s *MockMarathonServer) DropEventStreams() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.drop)
+	s.drop = make(chan struct{})
+}
+
+// FireEvent sends an event of eventType with data encoded as JSON to every connected event stream
+func (s *MockMarathonServer) FireEvent(eventType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("event: %s\r\ndata: %s\r\n\r\n", eventType, payload)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.streams) == 0 {
+		return fmt.Errorf("No event stream is connected to fire %s at", eventType)
+	}
+	for stream := range s.streams {
+		stream <- message
+	}
+	return nil
+}
+
+// FireStatusUpdate sends a status_update_event for the task taskID of appID, running on host with
+// ips, to every connected event stream
+func (s *MockMarathonServer) FireStatusUpdate(appID string, taskID string, taskStatus string, host string, ips ...string) error {
+	update := marathon.EventStatusUpdate{
+		EventType:  marathonapi.StatusUpdateEvent,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		TaskID:     taskID,
+		TaskStatus: taskStatus,
+		AppID:      appID,
+		Host:       host,
+	}
+	for _, ip := range ips {
+		update.IPAddresses = append(update.IPAddresses, &marathon.IPAddress{IPAddress: ip, Protocol: "IPv4"})
+	}
+	return s.FireEvent(marathonapi.StatusUpdateEvent, update)
+}
+
+func (s *MockMarathonServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	fail := false
+	if s.failures > 0 && (strings.HasPrefix(r.URL.Path, "/v2/apps") || strings.HasPrefix(r.URL.Path, "/v2/groups")) {
+		s.failures--
+		fail = true
+	}
+	s.mu.Unlock()
+
+	if fail {
+		http.Error(w, `{"message":"Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/ping":
+		fmt.Fprint(w, "pong")
+	case r.URL.Path == "/v2/events":
+		s.serveEvents(w, r)
+	case r.URL.Path == "/v2/apps" || r.URL.Path == "/v2/apps/":
+		s.mu.Lock()
+		apps := marathon.Applications{Apps: []marathon.Application{}}
+		for _, app := range s.apps {
+			apps.Apps = append(apps.Apps, *app)
+		}
+		s.mu.Unlock()
+		writeJSON(w, apps)
+	case strings.HasPrefix(r.URL.Path, "/v2/apps/"):
+		s.mu.Lock()
+		app, ok := s.apps[trimID(strings.TrimPrefix(r.URL.Path, "/v2/apps/"))]
+		s.mu.Unlock()
+		if !ok {
+			http.Error(w, `{"message":"App does not exist"}`, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, struct {
+			App *marathon.Application `json:"app"`
+		}{app})
+	case strings.HasPrefix(r.URL.Path, "/v2/groups"):
+		s.mu.Lock()
+		group, ok := s.groups[trimID(strings.TrimPrefix(r.URL.Path, "/v2/groups"))]
+		s.mu.Unlock()
+		if !ok {
+			http.Error(w, `{"message":"Group does not exist"}`, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, group)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveEvents streams the fired events until the client disconnects or the stream is dropped
+func (s *MockMarathonServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	stream := make(chan string, 16)
+	s.mu.Lock()
+	s.streams[stream] = true
+	drop := s.drop
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.streams, stream)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-drop:
+			return
+		case message := <-stream:
+			fmt.Fprint(w, message)
+			flusher.Flush()
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// trimID strips the slashes around an app or group id, Marathon ids are absolute while the paths of
+// the API are relative to /v2/apps and /v2/groups
+func trimID(id string) string {
+	return strings.Trim(id, "/")
+}
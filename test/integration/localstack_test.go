@@ -0,0 +1,251 @@
+//go:build integration
+
+// The tests of this module run the updater binary against Route53 as emulated by LocalStack, in a
+// container started with testcontainers-go, and against the Marathon of testutil.MockMarathonServer.
+// They need Docker and are run with: go test -tags integration ./...
+package integration
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DigDug101/marathon-dns-updater/internal/testutil"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	marathon "github.com/gambol99/go-marathon"
+	"github.com/testcontainers/testcontainers-go/modules/localstack"
+)
+
+const (
+	localstackImage = "localstack/localstack:3.8"
+	zoneName        = "example.com"
+	recordSet       = "marathon-lb.example.com"
+)
+
+// updaterBinary is the updater built for the tests by TestMain
+var updaterBinary string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "marathon-dns-updater")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	updaterBinary = filepath.Join(dir, "marathon-dns-updater")
+	// The updater is built in the root module, against its own dependencies
+	build := exec.Command("go", "build", "-o", updaterBinary, "./cmd/marathon-dns-updater")
+	build.Dir = filepath.Join("..", "..")
+	build.Stdout, build.Stderr = os.Stdout, os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to build the updater: %v\n", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// startLocalStack starts a LocalStack container for the test and returns the URL of its API
+func startLocalStack(t *testing.T) string {
+	t.Helper()
+	ctx := context.Background()
+	container, err := localstack.Run(ctx, localstackImage)
+	if err != nil {
+		t.Fatalf("Unable to start LocalStack: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Terminate(context.Background()); err != nil {
+			t.Logf("Unable to stop LocalStack: %v", err)
+		}
+	})
+
+	endpoint, err := container.PortEndpoint(ctx, "4566/tcp", "http")
+	if err != nil {
+		t.Fatalf("Unable to get the LocalStack endpoint: %v", err)
+	}
+	return endpoint
+}
+
+// newRoute53Client returns a Route53 client of the LocalStack API at endpoint
+func newRoute53Client(t *testing.T, endpoint string) *route53.Route53 {
+	t.Helper()
+	sess, err := session.NewSession(aws.NewConfig().
+		WithEndpoint(endpoint).
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials("test", "test", "")))
+	if err != nil {
+		t.Fatalf("Unable to create AWS session: %v", err)
+	}
+	return route53.New(sess)
+}
+
+// createHostedZone creates the hosted zone of zoneName, which is deleted along with its records once
+// the test is done
+func createHostedZone(t *testing.T, client *route53.Route53) string {
+	t.Helper()
+	output, err := client.CreateHostedZone(&route53.CreateHostedZoneInput{
+		Name:            aws.String(zoneName),
+		CallerReference: aws.String(fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano())),
+	})
+	if err != nil {
+		t.Fatalf("Unable to create hosted zone: %v", err)
+	}
+	zoneID := aws.StringValue(output.HostedZone.Id)
+
+	t.Cleanup(func() {
+		var changes []*route53.Change
+		err := client.ListResourceRecordSetsPages(&route53.ListResourceRecordSetsInput{HostedZoneId: aws.String(zoneID)},
+			func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+				for _, recordSet := range page.ResourceRecordSets {
+					switch aws.StringValue(recordSet.Type) {
+					case route53.RRTypeSoa, route53.RRTypeNs:
+					default:
+						changes = append(changes, &route53.Change{Action: aws.String(route53.ChangeActionDelete), ResourceRecordSet: recordSet})
+					}
+				}
+				return true
+			})
+		if err != nil {
+			t.Logf("Unable to list the records of hosted zone %s: %v", zoneID, err)
+			return
+		}
+		if len(changes) > 0 {
+			_, err := client.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+				HostedZoneId: aws.String(zoneID),
+				ChangeBatch:  &route53.ChangeBatch{Changes: changes},
+			})
+			if err != nil {
+				t.Logf("Unable to delete the records of hosted zone %s: %v", zoneID, err)
+				return
+			}
+		}
+		if _, err := client.DeleteHostedZone(&route53.DeleteHostedZoneInput{Id: aws.String(zoneID)}); err != nil {
+			t.Logf("Unable to delete hosted zone %s: %v", zoneID, err)
+		}
+	})
+	return zoneID
+}
+
+// runUpdater updates the records of the app /marathon-lb once and returns the output of the updater
+func runUpdater(t *testing.T, server *testutil.MockMarathonServer, endpoint string, zoneID string) string {
+	t.Helper()
+	cmd := exec.Command(updaterBinary,
+		"-once",
+		"-marathon-host", server.URL,
+		"-app-id", "/marathon-lb",
+		"-record-set", recordSet,
+		"-record-set-type", "weighted,enumerated",
+		"-hosted-zone-id", zoneID,
+		"-route53-endpoint-url", endpoint,
+	)
+	cmd.Env = append(os.Environ(), "AWS_ACCESS_KEY_ID=test", "AWS_SECRET_ACCESS_KEY=test", "AWS_REGION=us-east-1")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Updater failed: %v\n%s", err, output)
+	}
+	return string(output)
+}
+
+// aRecords returns the values of the A records of zoneID by name, without the trailing dot
+func aRecords(t *testing.T, client *route53.Route53, zoneID string) map[string][]string {
+	t.Helper()
+	records := map[string][]string{}
+	err := client.ListResourceRecordSetsPages(&route53.ListResourceRecordSetsInput{HostedZoneId: aws.String(zoneID)},
+		func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+			for _, recordSet := range page.ResourceRecordSets {
+				if aws.StringValue(recordSet.Type) != route53.RRTypeA {
+					continue
+				}
+				name := strings.TrimSuffix(aws.StringValue(recordSet.Name), ".")
+				for _, record := range recordSet.ResourceRecords {
+					records[name] = append(records[name], aws.StringValue(record.Value))
+				}
+			}
+			return true
+		})
+	if err != nil {
+		t.Fatalf("Unable to list the records of hosted zone %s: %v", zoneID, err)
+	}
+	for name := range records {
+		sort.Strings(records[name])
+	}
+	return records
+}
+
+// runningApp returns the app /marathon-lb with a running task for each of ips
+func runningApp(ips ...string) *marathon.Application {
+	app := &marathon.Application{ID: "/marathon-lb"}
+	for idx, ip := range ips {
+		app.Tasks = append(app.Tasks, &marathon.Task{
+			ID:          fmt.Sprintf("marathon-lb.%d", idx),
+			AppID:       "/marathon-lb",
+			Host:        "agent-" + ip,
+			State:       "TASK_RUNNING",
+			IPAddresses: []*marathon.IPAddress{{IPAddress: ip, Protocol: "IPv4"}},
+		})
+	}
+	return app
+}
+
+func expectRecords(t *testing.T, got map[string][]string, want map[string][]string) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("Expected the A records %v, got %v", want, got)
+	}
+}
+
+func TestRoute53Updates(t *testing.T) {
+	endpoint := startLocalStack(t)
+	client := newRoute53Client(t, endpoint)
+	zoneID := createHostedZone(t, client)
+
+	server := testutil.NewMockMarathonServer()
+	defer server.Close()
+
+	t.Run("initial creation", func(t *testing.T) {
+		server.SetApp(runningApp("10.0.0.1"))
+		runUpdater(t, server, endpoint, zoneID)
+		expectRecords(t, aRecords(t, client, zoneID), map[string][]string{
+			"marathon-lb.example.com":   {"10.0.0.1"},
+			"marathon-lb-1.example.com": {"10.0.0.1"},
+		})
+	})
+
+	t.Run("adding an IP", func(t *testing.T) {
+		server.SetApp(runningApp("10.0.0.1", "10.0.0.2"))
+		runUpdater(t, server, endpoint, zoneID)
+		expectRecords(t, aRecords(t, client, zoneID), map[string][]string{
+			"marathon-lb.example.com":   {"10.0.0.1", "10.0.0.2"},
+			"marathon-lb-1.example.com": {"10.0.0.1"},
+			"marathon-lb-2.example.com": {"10.0.0.2"},
+		})
+	})
+
+	t.Run("removing a stale IP", func(t *testing.T) {
+		server.SetApp(runningApp("10.0.0.2"))
+		runUpdater(t, server, endpoint, zoneID)
+		expectRecords(t, aRecords(t, client, zoneID), map[string][]string{
+			"marathon-lb.example.com":   {"10.0.0.2"},
+			"marathon-lb-1.example.com": {"10.0.0.2"},
+		})
+	})
+
+	t.Run("no changes", func(t *testing.T) {
+		before := aRecords(t, client, zoneID)
+		output := runUpdater(t, server, endpoint, zoneID)
+		if !strings.Contains(output, "No changes required for "+recordSet) {
+			t.Errorf("Expected the update to be skipped without changes, got:\n%s", output)
+		}
+		expectRecords(t, aRecords(t, client, zoneID), before)
+	})
+}